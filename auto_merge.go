@@ -0,0 +1,127 @@
+package bitcask_go
+
+import (
+	"sync"
+	"time"
+)
+
+// MergeStatus 后台自动 merge 的运行状态
+type MergeStatus struct {
+	Enabled      bool          // 是否开启了自动 merge
+	RunCount     uint64        // 自动 merge 实际执行的次数
+	LastRunAt    time.Time     // 最近一次执行的开始时间
+	LastDuration time.Duration // 最近一次执行的耗时
+	LastError    string        // 最近一次执行的错误信息，成功时为空
+}
+
+// mergeLimiter 限制同一进程中同时进行自动 merge 的数据库数量
+var mergeLimiter = struct {
+	mu      sync.Mutex
+	running int
+}{}
+
+// tryAcquireMergeSlot 尝试获取一个 merge 名额，limit 为 0 表示不限制
+func tryAcquireMergeSlot(limit int) bool {
+	mergeLimiter.mu.Lock()
+	defer mergeLimiter.mu.Unlock()
+	if limit > 0 && mergeLimiter.running >= limit {
+		return false
+	}
+	mergeLimiter.running++
+	return true
+}
+
+// releaseMergeSlot 释放 merge 名额
+func releaseMergeSlot() {
+	mergeLimiter.mu.Lock()
+	defer mergeLimiter.mu.Unlock()
+	mergeLimiter.running--
+}
+
+// startAutoMerge 根据配置项开启后台自动 merge 协程
+func (db *DB) startAutoMerge() {
+	if db.options.AutoMergeInterval <= 0 {
+		return
+	}
+	db.mergeStatus.Enabled = true
+	db.autoMergeStop = make(chan struct{})
+	db.autoMergeDone = make(chan struct{})
+	go db.autoMergeLoop(db.autoMergeStop, db.autoMergeDone)
+}
+
+// stopAutoMerge 通知后台自动 merge 协程退出，并等待其结束
+// 可以多次并发调用，只有第一次调用会通知协程退出，其余的调用等待第一次调用返回
+func (db *DB) stopAutoMerge() {
+	db.autoMergeStopOnce.Do(func() {
+		if db.autoMergeStop == nil {
+			return
+		}
+		close(db.autoMergeStop)
+		<-db.autoMergeDone
+	})
+}
+
+func (db *DB) autoMergeLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(db.options.AutoMergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			db.tryAutoMerge(now)
+		}
+	}
+}
+
+// tryAutoMerge 在时间窗口内，且可以 merge 的数据量达到阈值时执行 merge
+func (db *DB) tryAutoMerge(now time.Time) {
+	if !inMergeWindow(now, db.options.AutoMergeWindowStart, db.options.AutoMergeWindowEnd) {
+		return
+	}
+
+	db.mu.RLock()
+	empty := db.activeFile == nil
+	db.mu.RUnlock()
+	if empty {
+		return
+	}
+
+	if !tryAcquireMergeSlot(db.options.AutoMergeMaxConcurrency) {
+		return
+	}
+	defer releaseMergeSlot()
+
+	start := time.Now()
+	err := db.Merge()
+	// 未达到 merge 阈值或者已经有 merge 在进行，本次不算执行
+	if err == ErrMergeRatioUnreached || err == ErrMergeIsProgress {
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.mergeStatus.RunCount++
+	db.mergeStatus.LastRunAt = start
+	db.mergeStatus.LastDuration = time.Since(start)
+	db.mergeStatus.LastError = ""
+	if err != nil {
+		db.mergeStatus.LastError = err.Error()
+	}
+}
+
+// inMergeWindow 判断 now 是否处于一天中 [start, end) 的时间窗口内
+func inMergeWindow(now time.Time, start, end time.Duration) bool {
+	if start == end {
+		return true
+	}
+	year, month, day := now.Date()
+	offset := now.Sub(time.Date(year, month, day, 0, 0, 0, 0, now.Location()))
+	if start < end {
+		return offset >= start && offset < end
+	}
+	// 跨越零点的时间窗口
+	return offset >= start || offset < end
+}
@@ -0,0 +1,95 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInMergeWindow(t *testing.T) {
+	day := time.Date(2023, 9, 1, 0, 0, 0, 0, time.Local)
+
+	// 不限制
+	assert.True(t, inMergeWindow(day.Add(time.Hour), 0, 0))
+
+	// 普通的时间窗口
+	assert.True(t, inMergeWindow(day.Add(2*time.Hour), 2*time.Hour, 5*time.Hour))
+	assert.True(t, inMergeWindow(day.Add(4*time.Hour+59*time.Minute), 2*time.Hour, 5*time.Hour))
+	assert.False(t, inMergeWindow(day.Add(5*time.Hour), 2*time.Hour, 5*time.Hour))
+	assert.False(t, inMergeWindow(day.Add(time.Hour), 2*time.Hour, 5*time.Hour))
+
+	// 跨越零点的时间窗口
+	assert.True(t, inMergeWindow(day.Add(23*time.Hour), 22*time.Hour, 2*time.Hour))
+	assert.True(t, inMergeWindow(day.Add(time.Hour), 22*time.Hour, 2*time.Hour))
+	assert.False(t, inMergeWindow(day.Add(12*time.Hour), 22*time.Hour, 2*time.Hour))
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.AutoMergeInterval = time.Millisecond * 50
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+	stat := mustStat(t, db)
+	assert.True(t, stat.AutoMerge.Enabled)
+	assert.True(t, stat.AutoMerge.RunCount > 0)
+	assert.Equal(t, "", stat.AutoMerge.LastError)
+	assert.False(t, stat.AutoMerge.LastRunAt.IsZero())
+
+	// 关闭之后后台协程退出，重启校验数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+// 并发关闭数据库时只会通知一次后台协程退出
+func TestDB_AutoMerge_ConcurrentClose(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-close")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.AutoMergeInterval = time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	var wg sync.WaitGroup
+	var closed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if db.Close() == nil {
+				closed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), closed.Load())
+}
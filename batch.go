@@ -22,7 +22,7 @@ type WriteBatch struct {
 // NewWriteBatch 初始化 WriteBatch
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
   // 如果索引类型是 B+ 树，且保存事务序列号的文件不存在，同时不是第一次加载数据库（第一次加载文件一定为空），则禁用 WriteBatch 功能
-  if db.options.IndexerType == BPlusTreeIndex && !db.seqNoFileExist && !db.isInitial {
+  if !db.seqNoAvailable() {
     panic("cannot use write batch, seq no file not exists")
   }
   return &WriteBatch{
@@ -40,6 +40,9 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
   }
   wb.mu.Lock()
   defer wb.mu.Unlock()
+  if wb.db.closed.Load() {
+    return ErrDatabaseClosed
+  }
 
   // 暂存 LogRecord
   logRecord := &data.LogRecord{Key: key, Value: value}
@@ -54,6 +57,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
   }
   wb.mu.Lock()
   defer wb.mu.Unlock()
+  if wb.db.closed.Load() {
+    return ErrDatabaseClosed
+  }
 
   // 数据不存在则直接返回
   logRecordPos := wb.db.index.Get(key)
@@ -74,6 +80,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 func (wb *WriteBatch) Commit() error {
   wb.mu.Lock()
   defer wb.mu.Unlock()
+  if wb.db.closed.Load() {
+    return ErrDatabaseClosed
+  }
 
   if len(wb.pendingWrites) == 0 {
     return nil
@@ -83,17 +92,31 @@ func (wb *WriteBatch) Commit() error {
     return ErrExceedMaxBatchNum
   }
 
-  // 加锁保证事务提交串行化
-  wb.db.mu.Lock()
-  defer wb.db.mu.Unlock()
+  // 加锁保证事务提交串行化，根据配置在释放锁之后等待持久化
+  syncWrites := wb.options.SyncWrites || wb.db.options.SyncWrites
+  if err := wb.db.update(syncWrites, func() error {
+    return wb.db.commitPendingWrites(wb.pendingWrites)
+  }); err != nil {
+    return err
+  }
+
+  // 清空暂存数据，便于下次使用
+  wb.pendingWrites = make(map[string]*data.LogRecord)
+
+  return nil
+}
 
+// commitPendingWrites 以事务的形式写入暂存的数据，并更新内存索引，需要持有互斥锁
+// 每条数据的 key 都带上新分配的事务序列号，最后写入一条标识事务完成的数据
+func (db *DB) commitPendingWrites(pendingWrites map[string]*data.LogRecord) error {
+  db.metrics.batchCommitSize.observe(float64(len(pendingWrites)))
   // 获取当前最新的事务序列号
-  seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+  seqNo := atomic.AddUint64(&db.seqNo, 1)
 
   // 开始写数据到数据文件当中
   positions := make(map[string]*data.LogRecordPos)
-  for _, record := range wb.pendingWrites {
-    logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+  for _, record := range pendingWrites {
+    logRecordPos, err := db.appendLogRecord(&data.LogRecord{
       Key:   logRecordKeyWithSeq(record.Key, seqNo),
       Value: record.Value,
       Type:  record.Type,
@@ -109,38 +132,30 @@ func (wb *WriteBatch) Commit() error {
     Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
     Type: data.LogRecordTxnFinished,
   }
-  if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+  if _, err := db.appendLogRecord(finishedRecord); err != nil {
     return err
   }
 
-  // 根据配置决定是否持久化
-  if wb.options.SyncWrites && wb.db.activeFile != nil {
-    if err := wb.db.activeFile.Sync(); err != nil {
-      return err
-    }
-  }
-
   // 更新内存索引
-  for _, record := range wb.pendingWrites {
+  for _, record := range pendingWrites {
     pos := positions[string(record.Key)]
-    var oldPos *data.LogRecordPos
     if record.Type == data.LogRecordNormal {
-      oldPos = wb.db.index.Put(record.Key, pos)
+      db.putIndex(record.Key, pos)
     }
     if record.Type == data.LogRecordDeleted {
-      oldPos, _ = wb.db.index.Delete(record.Key)
-    }
-    if oldPos != nil {
-      wb.db.reclaimSize += int64(oldPos.Size)
+      db.deleteIndex(record.Key)
     }
+    db.trackTxnWrite(record.Key, seqNo)
   }
-
-  // 清空暂存数据，便于下次使用
-  wb.pendingWrites = make(map[string]*data.LogRecord)
-
   return nil
 }
 
+// seqNoAvailable 判断当前是否能获取到正确的事务序列号
+// B+ 树索引不会从数据文件中加载序列号，只能依赖保存事务序列号的文件
+func (db *DB) seqNoAvailable() bool {
+  return db.options.IndexerType != BPlusTreeIndex || db.seqNoFileExist || db.isInitial
+}
+
 // logRecordKeyWithSeq 对 Key 进行编码，在字节数组前加上变长的 seq number
 func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
   seq := make([]byte, binary.MaxVarintLen64)
@@ -157,3 +172,9 @@ func parseLogRcordKeyWithSeqNo(key []byte) ([]byte, uint64) {
   realKey := key[n:]
   return realKey, seqNo
 }
+
+// ParseLogRecordKey 解析数据文件中 LogRecord 的 key，获取实际的 key 和事务序列号，非事务数据的序列号为 0
+// 供检查和查看数据目录的工具使用
+func ParseLogRecordKey(key []byte) ([]byte, uint64) {
+  return parseLogRcordKeyWithSeqNo(key)
+}
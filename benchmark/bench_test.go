@@ -62,3 +62,51 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+func Benchmark_GetParallel(b *testing.B) {
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for pb.Next() {
+			_, err := db.Get(utils.GetTestKey(r.Intn(10000)))
+			if err != nil && err != bitcask.ErrKeyNotFound {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// 读多写少的混合负载，每 10 次操作中有 1 次写入
+func Benchmark_GetPutParallel(b *testing.B) {
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+	// RandomValue 不能并发调用，提前生成写入的 value
+	value := utils.RandomValue(1024)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		for pb.Next() {
+			key := utils.GetTestKey(r.Intn(10000))
+			if r.Intn(10) == 0 {
+				if err := db.Put(key, value); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+			_, err := db.Get(key)
+			if err != nil && err != bitcask.ErrKeyNotFound {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// blob 文件存储较大的 value，每条 blob 记录的 key 和数据文件中引用它的记录相同，value 按照配置压缩
+// 数据文件中的记录只保存 blob 的位置，使用 CompressionBlobRef 标识，读取时再从 blob 文件中取出实际的 value
+// 流式写入的 value 按照固定大小分块存储在多个 blob 中，数据文件中的记录保存所有分块的位置
+// blob 文件只按位置读取，不需要在启动时加载索引，也不需要文件结束标识
+
+// writeBlobValue 将不小于 BlobThreshold 的 value 写入活跃 blob 文件，返回引用该 blob 的新 LogRecord，需要持有互斥锁
+// 删除标记、事务完成标识、已经是 blob 引用或者已经压缩过的数据都原样返回
+func (db *DB) writeBlobValue(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.BlobThreshold <= 0 ||
+		logRecord.Type != data.LogRecordNormal ||
+		logRecord.Compression != data.CompressionNone ||
+		len(logRecord.Value) < db.options.BlobThreshold {
+		return logRecord, nil
+	}
+
+	// blob 中的 value 同样按照配置压缩
+	blobRecord, err := db.compressLogRecord(&data.LogRecord{Key: logRecord.Key, Value: logRecord.Value})
+	if err != nil {
+		return nil, err
+	}
+	blobPos, err := db.appendBlobRecord(blobRecord)
+	if err != nil {
+		return nil, err
+	}
+	return &data.LogRecord{
+		Key:         logRecord.Key,
+		Value:       data.EncodeBlobRef(&data.BlobRef{Chunks: []*data.LogRecordPos{blobPos}}),
+		Type:        logRecord.Type,
+		Expire:      logRecord.Expire,
+		Compression: data.CompressionBlobRef,
+	}, nil
+}
+
+// appendBlobRecord 将 blob 记录追加写入活跃 blob 文件，写满之后打开新的 blob 文件，需要持有互斥锁
+// 单个 value 超过 DataFileSize 时独占一个 blob 文件
+func (db *DB) appendBlobRecord(blobRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	encRecord, size, err := db.codec.EncodeLogRecord(blobRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.activeBlobFile == nil ||
+		db.activeBlobFile.WriteOff > 0 && db.activeBlobFile.WriteOff+size > db.options.DataFileSize {
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.blobUnsynced = true
+	db.metrics.bytesWritten.Add(uint64(size))
+	return &data.LogRecordPos{
+		Fid:    db.activeBlobFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}, nil
+}
+
+// setActiveBlobFile 持久化当前的活跃 blob 文件，并打开新的活跃 blob 文件，需要持有互斥锁
+func (db *DB) setActiveBlobFile() error {
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
+	// blob 文件 id 只增不减，merge 删除的 blob 文件 id 不会被重新使用
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, db.nextBlobFileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	db.nextBlobFileId++
+	blobFile.Codec = db.codec
+	db.activeBlobFile = blobFile
+	db.blobFiles[blobFile.FileId] = blobFile
+	db.publishFiles()
+	// 新的文件写入数据之前先记录到 MANIFEST 中
+	return db.saveManifest()
+}
+
+// syncBlobFile 持久化活跃 blob 文件中还没有持久化的数据，需要持有互斥锁
+// 引用 blob 的记录持久化之前，blob 需要先持久化
+func (db *DB) syncBlobFile() error {
+	if db.activeBlobFile == nil || !db.blobUnsynced {
+		return nil
+	}
+	if err := db.syncFile(db.activeBlobFile, true); err != nil {
+		return err
+	}
+	db.blobUnsynced = false
+	return nil
+}
+
+// syncActiveFiles 先持久化活跃 blob 文件，再持久化活跃数据文件，需要持有互斥锁
+// 之前的写入都已经持久化，等待持久化的写入可以直接更新索引
+func (db *DB) syncActiveFiles() error {
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
+	if err := db.syncFile(db.activeFile, false); err != nil {
+		return err
+	}
+	db.settleUnsyncedWrites(db.writeSeq, nil)
+	return nil
+}
+
+// readBlobValue 根据数据文件记录中保存的 blob 引用读取实际的 value，分块存储的 value 读出所有分块后拼接
+func readBlobValue(blobFiles map[uint32]*data.DataFile, buf []byte) ([]byte, error) {
+	ref, err := data.DecodeBlobRef(buf)
+	if err != nil {
+		return nil, err
+	}
+	if !ref.Chunked() {
+		return readBlob(blobFiles, ref.Chunks[0])
+	}
+	value := make([]byte, 0, ref.ValueSize)
+	for i, blobPos := range ref.Chunks {
+		chunk, err := readBlob(blobFiles, blobPos)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(chunk)) != ref.ChunkLen(i) {
+			return nil, data.ErrInvalidBlobRef
+		}
+		value = append(value, chunk...)
+	}
+	return value, nil
+}
+
+// readBlob 读取一个 blob 并解压
+func readBlob(blobFiles map[uint32]*data.DataFile, blobPos *data.LogRecordPos) ([]byte, error) {
+	blobFile := blobFiles[blobPos.Fid]
+	if blobFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	blobRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return data.DecompressValue(blobRecord.Compression, blobRecord.Value)
+}
+
+// loadBlobFiles 打开数据目录中的 blob 文件，最后一个 blob 文件作为活跃 blob 文件继续写入
+// 和数据文件一样，有 MANIFEST 时只加载其中记录的文件，没有被记录的文件直接删除
+// 已经打开的文件不会重新打开，只读模式下 Refresh 时只需要打开新增的文件
+func (db *DB) loadBlobFiles() error {
+	fileIds, err := db.listBlobFileIds()
+	if err != nil {
+		return err
+	}
+	ioType := fio.StandardFIO
+	if db.options.ReadOnly {
+		ioType = fio.ReadOnlyFIO
+	}
+	for _, fid := range fileIds {
+		if _, ok := db.blobFiles[fid]; ok {
+			continue
+		}
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, fid, ioType)
+		if err != nil {
+			return err
+		}
+		blobFile.Codec = db.codec
+		db.blobFiles[fid] = blobFile
+		db.nextBlobFileId = fid + 1
+	}
+	if len(fileIds) == 0 {
+		return nil
+	}
+
+	activeBlobFile := db.blobFiles[fileIds[len(fileIds)-1]]
+	size, err := activeBlobFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	activeBlobFile.WriteOff = size
+	db.activeBlobFile = activeBlobFile
+	return nil
+}
+
+// listBlobFileIds 获取需要加载的 blob 文件 id，从小到大排序
+func (db *DB) listBlobFileIds() ([]uint32, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []uint32
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileNameSuffix))
+		if err != nil {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		fileIds = append(fileIds, uint32(fileId))
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	if db.manifest == nil {
+		return fileIds, nil
+	}
+
+	listed := make(map[uint32]struct{}, len(db.manifest.blobFileIds))
+	for _, fid := range db.manifest.blobFileIds {
+		listed[fid] = struct{}{}
+	}
+	existing := make(map[uint32]struct{}, len(fileIds))
+	var recovered []uint32
+	for _, fid := range fileIds {
+		existing[fid] = struct{}{}
+		if _, ok := listed[fid]; ok || db.options.ReadOnly {
+			continue
+		}
+		// 和数据文件一样，id 大于 MANIFEST 中所有文件的 blob 文件可能已经被数据文件引用，需要保留
+		if n := len(db.manifest.blobFileIds); n == 0 || fid > db.manifest.blobFileIds[n-1] {
+			recovered = append(recovered, fid)
+			continue
+		}
+		if err := os.Remove(data.GetBlobFileName(db.options.DirPath, fid)); err != nil {
+			return nil, err
+		}
+	}
+	for _, fid := range db.manifest.blobFileIds {
+		if _, ok := existing[fid]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrDataFileMissing, filepath.Base(data.GetBlobFileName(db.options.DirPath, fid)))
+		}
+	}
+	return append(append([]uint32{}, db.manifest.blobFileIds...), recovered...), nil
+}
+
+// blobFilesSize 所有 blob 文件的大小，需要持有互斥锁
+func (db *DB) blobFilesSize() (int64, error) {
+	var total int64
+	for _, blobFile := range db.blobFiles {
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
@@ -0,0 +1,209 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := func(i, version int) []byte {
+		return bytes.Repeat(append(utils.GetTestKey(i), byte(version)), 400)
+	}
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i, 0)))
+	}
+	// 小于阈值的 value 依然存储在数据文件中
+	assert.Nil(t, db.Put([]byte("small"), []byte("small value")))
+	// 超过数据文件大小的 value 独占一个 blob 文件
+	huge := bytes.Repeat([]byte("huge"), int(opts.DataFileSize))
+	assert.Nil(t, db.Put([]byte("huge"), huge))
+	assert.Greater(t, len(db.blobFiles), 2)
+	assert.Equal(t, 0, len(db.olderFiles))
+
+	checkData := func(db *DB, version int) {
+		for i := 0; i < 200; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value(i, version), val)
+		}
+		val, err := db.Get([]byte("small"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("small value"), val)
+		val, err = db.Get([]byte("huge"))
+		assert.Nil(t, err)
+		assert.Equal(t, huge, val)
+	}
+	checkData(db, 0)
+
+	// 迭代器同样可以读取 blob 中的 value
+	iter := db.NewIterator(IteratorOptions{Prefix: []byte("huge")})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	val, err := iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, huge, val)
+	iter.Close()
+
+	// 重新打开之后继续写入最后一个 blob 文件
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkData(db, 0)
+	blobFileIds := make(map[uint32]struct{})
+	for fid := range db.blobFiles {
+		blobFileIds[fid] = struct{}{}
+	}
+
+	// 覆盖全部数据之后，旧的 blob 文件中没有有效数据，merge 时直接删除
+	snapshot, err := db.NewSnapshot()
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value(i, 1)))
+	}
+	assert.Nil(t, db.Merge())
+	checkData(db, 1)
+	for fid := range blobFileIds {
+		_, err := os.Stat(data.GetBlobFileName(dir, fid))
+		if _, ok := db.blobFiles[fid]; ok {
+			assert.Nil(t, err)
+		} else {
+			assert.True(t, os.IsNotExist(err))
+		}
+	}
+	// huge 所在的文件仍然有效，不需要重写
+	assert.Less(t, len(db.blobFiles), len(blobFileIds)+3)
+
+	// 快照引用的 blob 文件在释放之前依然可以读取
+	val, err = snapshot.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, value(10, 0), val)
+	snapshot.Release()
+	assert.Equal(t, 0, len(db.obsoleteFiles))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checkData(db, 1)
+	destroyDB(db)
+}
+
+func TestDB_Blob_GC(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-gc")
+	opts.DirPath = dir
+	opts.DataFileSize = 256 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 512
+	opts.BlobGCRatio = 0.5
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.Equal(t, 1, len(db.blobFiles))
+	var oldBlobFileId uint32
+	for fid := range db.blobFiles {
+		oldBlobFileId = fid
+	}
+
+	// 删除大部分数据，blob 文件中的无效数据超过阈值，merge 时重写其中的有效数据
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	values := make(map[int][]byte)
+	for i := 150; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		values[i] = val
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.blobFiles[oldBlobFileId])
+	_, err = os.Stat(data.GetBlobFileName(dir, oldBlobFileId))
+	assert.True(t, os.IsNotExist(err))
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// 没有记录在 MANIFEST 中并且 id 小于已记录文件的 blob 文件在打开时被删除
+	assert.Nil(t, db.Close())
+	orphan := data.GetBlobFileName(dir, oldBlobFileId)
+	assert.Nil(t, os.WriteFile(orphan, []byte("orphan"), 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 50, len(db.ListKeys()))
+	destroyDB(db)
+}
+
+// 无效数据比例没有达到阈值的 blob 文件在 merge 时保持不变
+func TestDB_Blob_GCRatio(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-gc-ratio")
+	opts.DirPath = dir
+	opts.DataFileSize = 256 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 512
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.Equal(t, 1, len(db.blobFiles))
+	var blobFileId uint32
+	for fid := range db.blobFiles {
+		blobFileId = fid
+	}
+	blobFileName := data.GetBlobFileName(dir, blobFileId)
+	info, err := os.Stat(blobFileName)
+	assert.Nil(t, err)
+
+	// 默认的阈值下，大部分数据有效的 blob 文件不会被重写
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.NotNil(t, db.blobFiles[blobFileId])
+	info2, err := os.Stat(blobFileName)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(info, info2))
+	assert.Equal(t, info.Size(), info2.Size())
+
+	// 阈值为 0 时只删除没有有效数据的 blob 文件
+	db.options.BlobGCRatio = 0
+	for i := 20; i < 190; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.NotNil(t, db.blobFiles[blobFileId])
+	for i := 190; i < 200; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 190; i < 200; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.blobFiles[blobFileId])
+	_, err = os.Stat(blobFileName)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,244 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint 文件的前两条记录分别保存元信息和每个数据文件的无效数据量，之后每条记录对应索引中的一个 key
+const (
+	checkpointMetaKey      = "checkpoint.meta"
+	checkpointDeadBytesKey = "checkpoint.dead-bytes"
+)
+
+// indexCheckpointMeta index checkpoint 的元信息
+type indexCheckpointMeta struct {
+	fileId      uint32 // checkpoint 覆盖到的数据文件 id
+	offset      int64  // checkpoint 覆盖到的文件偏移，之后写入的数据需要从数据文件中加载
+	seqNo       uint64 // checkpoint 时的事务序列号
+	reclaimSize int64  // checkpoint 时无效数据的大小
+}
+
+// CheckpointIndex 将内存索引保存到 checkpoint 文件中
+// 下次启动时直接加载 checkpoint，只需要再从数据文件中加载 checkpoint 之后写入的数据
+// merge 和 compaction 会改变数据的位置，完成后之前的 checkpoint 会失效并被删除
+// B+ 树索引本身就存储在磁盘上，不需要 checkpoint
+func (db *DB) CheckpointIndex() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.IndexerType == BPlusTreeIndex {
+		return nil
+	}
+
+	// 持有互斥锁拷贝索引，保证索引和覆盖到的文件位置是一致的
+	db.mu.Lock()
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return ErrDatabaseClosed
+	}
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// checkpoint 覆盖到的数据需要先持久化
+	if err := db.syncActiveFiles(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	indexer, err := db.index.Clone()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	meta := &indexCheckpointMeta{
+		fileId:      db.activeFile.FileId,
+		offset:      db.activeFile.WriteOff,
+		seqNo:       db.seqNo,
+		reclaimSize: db.reclaimSize,
+	}
+	deadBytes := make(map[uint32]int64, len(db.fileDeadBytes))
+	for fid, size := range db.fileDeadBytes {
+		deadBytes[fid] = size
+	}
+	epoch := db.indexEpoch
+	db.mu.Unlock()
+	defer func() {
+		_ = indexer.Close()
+	}()
+
+	// 先写入临时文件
+	buf, err := encodeIndexCheckpoint(meta, deadBytes, indexer, db.codec)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	tmpFileName := fileName + ".tmp"
+	if err := utils.WriteFileSync(tmpFileName, buf, fio.DataFilePerm); err != nil {
+		return err
+	}
+
+	// 写入期间发生了 merge 或 compaction，拷贝的索引已经失效
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.indexEpoch != epoch {
+		return os.Remove(tmpFileName)
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// encodeIndexCheckpoint 编码 checkpoint 文件的内容，checkpoint 中保存了所有的 key，和数据文件一样需要加密
+func encodeIndexCheckpoint(meta *indexCheckpointMeta, deadBytes map[uint32]int64,
+	indexer index.Indexer, codec *data.Codec) ([]byte, error) {
+	var buf []byte
+
+	metaBuf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*3)
+	var n = 0
+	n += binary.PutVarint(metaBuf[n:], int64(meta.fileId))
+	n += binary.PutVarint(metaBuf[n:], meta.offset)
+	n += binary.PutUvarint(metaBuf[n:], meta.seqNo)
+	n += binary.PutVarint(metaBuf[n:], meta.reclaimSize)
+	encRecord, _, err := codec.EncodeLogRecord(&data.LogRecord{Key: []byte(checkpointMetaKey), Value: metaBuf[:n]})
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, encRecord...)
+
+	var deadBuf []byte
+	for fid, size := range deadBytes {
+		deadBuf = binary.AppendVarint(deadBuf, int64(fid))
+		deadBuf = binary.AppendVarint(deadBuf, size)
+	}
+	encRecord, _, err = codec.EncodeLogRecord(&data.LogRecord{Key: []byte(checkpointDeadBytesKey), Value: deadBuf})
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, encRecord...)
+
+	iterator := indexer.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		encRecord, _, err = codec.EncodeLogRecord(&data.LogRecord{
+			Key:   iterator.Key(),
+			Value: data.EncodeLogRecordPos(iterator.Value()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, encRecord...)
+	}
+	return buf, nil
+}
+
+// loadIndexCheckpoint 从 checkpoint 文件中加载索引，返回 checkpoint 覆盖到的数据文件位置
+// checkpoint 不存在、已经损坏或者和数据文件不匹配时 ok 为 false，需要完整地加载索引
+func (db *DB) loadIndexCheckpoint() (fileId uint32, offset int64, ok bool, err error) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, 0, false, nil
+	}
+	checkpointFile, err := data.OpenIndexCheckpointFile(db.options.DirPath)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	checkpointFile.Codec = db.codec
+	defer func() {
+		_ = checkpointFile.Close()
+	}()
+
+	// 先读出全部记录，确认 checkpoint 完整可用之后再更新索引
+	var records []*data.LogRecord
+	var readOffset int64 = 0
+	for {
+		record, size, err := checkpointFile.ReadLogRecord(readOffset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, false, nil
+		}
+		records = append(records, record)
+		readOffset += size
+	}
+	if len(records) < 2 ||
+		string(records[0].Key) != checkpointMetaKey ||
+		string(records[1].Key) != checkpointDeadBytesKey {
+		return 0, 0, false, nil
+	}
+	meta := decodeIndexCheckpointMeta(records[0].Value)
+
+	// checkpoint 覆盖到的数据必须还在数据文件中
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == meta.fileId {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[meta.fileId]
+	}
+	if dataFile == nil {
+		return 0, 0, false, nil
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if size < meta.offset {
+		return 0, 0, false, nil
+	}
+
+	db.seqNo = meta.seqNo
+	db.reclaimSize = meta.reclaimSize
+	deadBuf := records[1].Value
+	for len(deadBuf) > 0 {
+		fid, n := binary.Varint(deadBuf)
+		deadBuf = deadBuf[n:]
+		deadSize, n := binary.Varint(deadBuf)
+		deadBuf = deadBuf[n:]
+		db.fileDeadBytes[uint32(fid)] = deadSize
+	}
+	now := time.Now().UnixNano()
+	for _, record := range records[2:] {
+		pos := data.DecodeLogRecordPos(record.Value)
+		// 已经过期的数据不再加载
+		if isExpired(pos, now) {
+			db.markReclaimable(pos)
+			continue
+		}
+		db.index.Put(record.Key, pos)
+	}
+	return meta.fileId, meta.offset, true, nil
+}
+
+// decodeIndexCheckpointMeta 解码 checkpoint 的元信息
+func decodeIndexCheckpointMeta(buf []byte) *indexCheckpointMeta {
+	var index = 0
+	fileId, n := binary.Varint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	reclaimSize, _ := binary.Varint(buf[index:])
+	return &indexCheckpointMeta{
+		fileId:      uint32(fileId),
+		offset:      offset,
+		seqNo:       seqNo,
+		reclaimSize: reclaimSize,
+	}
+}
+
+// invalidateIndexCheckpoint merge 或 compaction 改变了数据的位置，删除已经失效的 checkpoint，需要持有互斥锁
+func (db *DB) invalidateIndexCheckpoint() error {
+	db.indexEpoch++
+	err := os.Remove(filepath.Join(db.options.DirPath, data.IndexCheckpointFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
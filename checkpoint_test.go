@@ -0,0 +1,116 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_CheckpointIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空数据库
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+
+	// checkpoint 之后写入的数据
+	for i := 2000; i < 2500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 500; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	seqNo, reclaimSize := db.seqNo, db.reclaimSize
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// checkpoint 覆盖到的数据文件不会再被读取
+	assert.Nil(t, os.WriteFile(data.GetDataFileName(dir, 0), []byte("corrupted"), 0644))
+	assert.Nil(t, removeHintFile(dir, 0))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	assert.Equal(t, seqNo, db2.seqNo)
+	assert.Equal(t, reclaimSize, db2.reclaimSize)
+	for i := 1000; i < 2500; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_CheckpointIndex_Invalidate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-invalidate")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexCheckpointOnClose = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	checkpointFile := filepath.Join(dir, data.IndexCheckpointFileName)
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+	_, err = os.Stat(checkpointFile)
+	assert.Nil(t, err)
+
+	// merge 之后 checkpoint 失效
+	err = db.Merge()
+	assert.Nil(t, err)
+	_, err = os.Stat(checkpointFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// 关闭时保存 checkpoint
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(checkpointFile)
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	for i := 1000; i < 2000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db2.Close())
+}
@@ -0,0 +1,134 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Checksum(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checksum")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+
+	// 每次重新打开时切换校验算法，不同校验算法的数据可以共存
+	for i, checksum := range []ChecksumType{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+		opts.Checksum = checksum
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		for j := i * 1000; j < (i+1)*1000; j++ {
+			err := db.Put(utils.GetTestKey(j), utils.RandomValue(128))
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, db.Close())
+	}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, mustStat(t, db).Recovery)
+	assert.Equal(t, 3000, len(db.ListKeys()))
+	// 旧数据文件都以结束标识结尾，使用 64 位校验值的结束标识多占用 4 个字节
+	assert.Greater(t, len(db.olderFiles), 3)
+	for _, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.True(t, dataFile.IsEndOfFile(size-7) || dataFile.IsEndOfFile(size-11))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	destroyDB(db)
+
+	opts.Checksum = ChecksumXXHash64 + 1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Checksum_MergeLargerRecords(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checksum-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Checksum = ChecksumCRC32C
+
+	values := make(map[string][]byte)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 5000; i++ {
+		value := utils.RandomValue(16)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		values[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, db.Close())
+
+	// 使用 64 位校验值重新写入后每条记录都变大，merge 生成的数据文件比原来多
+	opts.Checksum = ChecksumXXHash64
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	oldFileNum := len(db.olderFiles)
+	assert.Nil(t, db.Merge())
+	for i := 0; i < 100; i++ {
+		value := utils.RandomValue(16)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		values[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Greater(t, len(db.olderFiles), oldFileNum)
+	assert.Equal(t, len(values), len(db.ListKeys()))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_EndOfFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-end-of-file")
+	opts.DirPath = dir
+	opts.RecoveryMode = RecoveryStrict
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	fileId := db.activeFile.FileId
+	assert.Nil(t, db.Close())
+
+	// 写入结束标识之后，打开新的活跃文件之前发生了崩溃
+	file, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	_, err = file.Write((&data.Codec{Checksum: ChecksumCRC32C}).EncodeEndOfFile())
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// 不当作损坏的数据，直接转换成旧文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, mustStat(t, db).Recovery)
+	assert.Equal(t, fileId+1, db.activeFile.FileId)
+	assert.NotNil(t, db.olderFiles[fileId])
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db.ListKeys()))
+	destroyDB(db)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bitcask-go/fsck"
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	dir := flag.String("dir", "", "database directory to check")
+	repair := flag.String("repair", "", "write a repaired copy of the database directory to this path")
+	flag.Parse()
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var report *fsck.Report
+	var err error
+	if *repair != "" {
+		report, err = fsck.Repair(*dir, *repair)
+	} else {
+		report, err = fsck.Check(*dir)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-fsck: %v\n", err)
+		os.Exit(2)
+	}
+
+	for _, issue := range report.Errors {
+		fmt.Printf("ERROR   %s\n", issue)
+	}
+	for _, issue := range report.Warnings {
+		fmt.Printf("WARNING %s\n", issue)
+	}
+	fmt.Printf("%d data files, %d records, %d bad bytes, %d incomplete txns, %d errors, %d warnings\n",
+		report.DataFiles, report.Records, report.BadBytes, report.IncompleteTxns, len(report.Errors), len(report.Warnings))
+	if *repair != "" {
+		fmt.Printf("repaired directory written to %s\n", *repair)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	dirPath    = flag.String("dir", "", "database directory to inspect")
+	fileFilter = flag.Int("file", -1, "only print records of this data file id")
+	keyPrefix  = flag.String("prefix", "", "only print records whose key starts with this prefix")
+	showHints  = flag.Bool("hint", false, "also print records of hint files")
+	previewLen = flag.Int("preview", 32, "max number of value bytes to print")
+	showUsage  = flag.Bool("usage", false, "print live and dead bytes of every data file against the current index, "+
+		"the database is opened and must not be used by another process")
+	indexType = flag.String("index", "btree", "index type used to open the database for -usage: btree, art or bptree")
+)
+
+func main() {
+	flag.Parse()
+	if *dirPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *showUsage {
+		err = printUsage()
+	} else {
+		err = printRecords()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-inspect: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printRecords 打印数据文件和 hint 文件中的记录
+func printRecords() error {
+	fileIds, err := listFileIds(data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if *fileFilter >= 0 && uint32(*fileFilter) != fid {
+			continue
+		}
+		if err := printDataFile(fid); err != nil {
+			return err
+		}
+	}
+	if !*showHints {
+		return nil
+	}
+
+	hintIds, err := listFileIds(data.HintFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, fid := range hintIds {
+		if *fileFilter >= 0 && uint32(*fileFilter) != fid {
+			continue
+		}
+		hintFile, err := data.OpenDataHintFile(*dirPath, fid)
+		if err != nil {
+			return err
+		}
+		err = printHintFile(hintFile, filepath.Base(data.GetHintFileName(*dirPath, fid)), true)
+		_ = hintFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// merge 生成的 hint 文件
+	if _, err := os.Stat(filepath.Join(*dirPath, data.HintFileName)); err == nil {
+		hintFile, err := data.OpenHintFile(*dirPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = hintFile.Close()
+		}()
+		return printHintFile(hintFile, data.HintFileName, false)
+	}
+	return nil
+}
+
+func printDataFile(fileId uint32) error {
+	dataFile, err := data.OpenDataFile(*dirPath, fileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dataFile.Close()
+	}()
+
+	name := filepath.Base(data.GetDataFileName(*dirPath, fileId))
+	return scanFile(dataFile, name, func(record *data.LogRecord, offset, size int64) {
+		realKey, seqNo := bitcask.ParseLogRecordKey(record.Key)
+		if !strings.HasPrefix(string(realKey), *keyPrefix) {
+			return
+		}
+		// 存储在 blob 文件中的数据打印 blob 的位置，分块存储的数据打印分块数量和 value 的总长度
+		if record.Compression == data.CompressionBlobRef {
+			ref, err := data.DecodeBlobRef(record.Value)
+			if err != nil {
+				fmt.Printf("%s offset=%d size=%d key=%s %v\n", name, offset, size, preview(realKey, len(realKey)), err)
+				return
+			}
+			blob := fmt.Sprintf("blob=%d:%d+%d", ref.Chunks[0].Fid, ref.Chunks[0].Offset, ref.Chunks[0].Size)
+			if ref.Chunked() {
+				blob = fmt.Sprintf("chunks=%d value-size=%d", len(ref.Chunks), ref.ValueSize)
+			}
+			fmt.Printf("%s offset=%d size=%d type=%s seq=%d expire=%s key=%s %s\n",
+				name, offset, size, typeName(record.Type), seqNo, expireTime(record.Expire),
+				preview(realKey, len(realKey)), blob)
+			return
+		}
+		// 压缩过的数据打印解压后的内容
+		value, err := data.DecompressValue(record.Compression, record.Value)
+		if err != nil {
+			fmt.Printf("%s offset=%d size=%d key=%s %v\n", name, offset, size, preview(realKey, len(realKey)), err)
+			return
+		}
+		fmt.Printf("%s offset=%d size=%d type=%s seq=%d expire=%s compression=%s key=%s value=%s\n",
+			name, offset, size, typeName(record.Type), seqNo, expireTime(record.Expire),
+			compressionName(record.Compression), preview(realKey, len(realKey)), preview(value, *previewLen))
+	})
+}
+
+// printHintFile 打印 hint 文件中的索引，每个数据文件对应的 hint 文件中的 key 带有事务序列号，merge 生成的 hint 文件中没有
+func printHintFile(hintFile *data.DataFile, name string, withSeqNo bool) error {
+	return scanFile(hintFile, name, func(record *data.LogRecord, offset, size int64) {
+		realKey, seqNo := record.Key, uint64(0)
+		if withSeqNo {
+			realKey, seqNo = bitcask.ParseLogRecordKey(record.Key)
+		}
+		if !strings.HasPrefix(string(realKey), *keyPrefix) {
+			return
+		}
+		pos := data.DecodeLogRecordPos(record.Value)
+		if !withSeqNo && *fileFilter >= 0 && uint32(*fileFilter) != pos.Fid {
+			return
+		}
+		fmt.Printf("%s offset=%d size=%d type=%s seq=%d expire=%s key=%s pos=%d:%d+%d\n",
+			name, offset, size, typeName(record.Type), seqNo, expireTime(pos.Expire),
+			preview(realKey, len(realKey)), pos.Fid, pos.Offset, pos.Size)
+	})
+}
+
+// scanFile 顺序读取文件中的所有记录，遇到损坏的数据时停止，损坏的详细情况可以使用 bitcask-fsck 检查
+func scanFile(dataFile *data.DataFile, name string, fn func(record *data.LogRecord, offset, size int64)) error {
+	var offset int64 = 0
+	for {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			fileSize, err := dataFile.IoManager.Size()
+			if err != nil {
+				return err
+			}
+			if offset < fileSize && dataFile.IsEndOfFile(offset) {
+				fmt.Printf("%s offset=%d end of file, %d bytes of padding\n", name, offset, fileSize-offset)
+			} else if offset < fileSize {
+				fmt.Printf("%s offset=%d incomplete record, %d bytes left\n", name, offset, fileSize-offset)
+			}
+			return nil
+		}
+		if err == data.ErrInvalidCRC {
+			fmt.Printf("%s offset=%d invalid crc, stop reading this file\n", name, offset)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(record, offset, size)
+		offset += size
+	}
+}
+
+// printUsage 打开数据库，根据内存索引打印每个数据文件中有效数据和无效数据的大小
+func printUsage() error {
+	options := bitcask.DefaultOptions
+	options.DirPath = *dirPath
+	switch *indexType {
+	case "btree":
+		options.IndexerType = bitcask.BTreeIndex
+	case "art":
+		options.IndexerType = bitcask.ARTIndex
+	case "bptree":
+		options.IndexerType = bitcask.BPlusTreeIndex
+	default:
+		return fmt.Errorf("unknown index type %q", *indexType)
+	}
+	if _, err := os.Stat(*dirPath); err != nil {
+		return err
+	}
+
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+	usages, err := db.DataFileUsage()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-16s %12s %10s %12s %12s %7s\n", "file", "size", "live keys", "live bytes", "dead bytes", "dead%")
+	var total bitcask.DataFileUsage
+	for _, usage := range usages {
+		if *fileFilter >= 0 && uint32(*fileFilter) != usage.FileId {
+			continue
+		}
+		printUsageLine(filepath.Base(data.GetDataFileName(*dirPath, usage.FileId)), usage)
+		total.Size += usage.Size
+		total.LiveKeys += usage.LiveKeys
+		total.LiveBytes += usage.LiveBytes
+		total.DeadBytes += usage.DeadBytes
+	}
+	printUsageLine("total", total)
+	return nil
+}
+
+func printUsageLine(name string, usage bitcask.DataFileUsage) {
+	var ratio float64
+	if usage.Size > 0 {
+		ratio = float64(usage.DeadBytes) * 100 / float64(usage.Size)
+	}
+	fmt.Printf("%-16s %12d %10d %12d %12d %6.1f%%\n",
+		name, usage.Size, usage.LiveKeys, usage.LiveBytes, usage.DeadBytes, ratio)
+}
+
+// listFileIds 列出目录中指定后缀的文件 id，从小到大排序
+func listFileIds(suffix string) ([]uint32, error) {
+	entries, err := os.ReadDir(*dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []uint32
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(name, suffix))
+		if err != nil {
+			continue
+		}
+		fileIds = append(fileIds, uint32(fileId))
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds, nil
+}
+
+func typeName(typ data.LogRecordType) string {
+	switch typ {
+	case data.LogRecordNormal:
+		return "normal"
+	case data.LogRecordDeleted:
+		return "deleted"
+	case data.LogRecordTxnFinished:
+		return "txn-fin"
+	case data.LogRecordEndOfFile:
+		return "end-of-file"
+	default:
+		return fmt.Sprintf("unknown(%d)", typ)
+	}
+}
+
+func compressionName(typ data.CompressionType) string {
+	switch typ {
+	case data.CompressionNone:
+		return "none"
+	case data.CompressionSnappy:
+		return "snappy"
+	case data.CompressionDeflate:
+		return "deflate"
+	case data.CompressionBlobRef:
+		return "blob"
+	default:
+		return fmt.Sprintf("unknown(%d)", typ)
+	}
+}
+
+func expireTime(expire int64) string {
+	if expire == 0 {
+		return "-"
+	}
+	return time.Unix(0, expire).Format(time.RFC3339)
+}
+
+// preview 打印数据的前 n 个字节，超过的部分只打印总长度
+func preview(buf []byte, n int) string {
+	if len(buf) <= n {
+		return strconv.Quote(string(buf))
+	}
+	return fmt.Sprintf("%s...(%d bytes)", strconv.Quote(string(buf[:n])), len(buf))
+}
@@ -0,0 +1,22 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// newCodec 根据配置项创建编码记录使用的 Codec，决定校验算法以及是否加密
+// MANIFEST 中只有格式版本和文件列表等元信息，不加密，打开数据库时可以在解密数据之前检查目录是否兼容
+func newCodec(options Options) (*data.Codec, error) {
+	codec := &data.Codec{Checksum: options.Checksum}
+	provider := options.KeyProvider
+	if provider == nil && len(options.EncryptionKey) > 0 {
+		provider = data.NewStaticKeyProvider(options.EncryptionKey)
+	}
+	if provider == nil {
+		return codec, nil
+	}
+	keyring, err := data.NewKeyring(provider)
+	if err != nil {
+		return nil, err
+	}
+	codec.Keyring = keyring
+	return codec, nil
+}
@@ -0,0 +1,235 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Compact 增量 merge，在数据库运行期间回收无效数据
+// 挑选出无效数据占比最高的若干个旧数据文件，只将其中的有效数据重写到活跃文件中，
+// 原地更新内存索引后直接删除这些旧文件，不需要额外的 merge 目录，也不需要重启数据库
+func (db *DB) Compact() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	// 如果数据库为空，则直接返回
+	if db.activeFile == nil {
+		return nil
+	}
+
+	db.mu.Lock()
+	// Compact 和 Merge 不能同时进行
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	compactFiles, err := db.pickCompactFiles()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// 没有达到阈值的文件
+	if len(compactFiles) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	// 从小到大依次处理每个文件
+	for _, dataFile := range compactFiles {
+		if err := db.compactDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickCompactFiles 挑选出无效数据占比达到阈值的旧数据文件，需要持有互斥锁
+// 按照无效数据占比从高到低最多取 CompactMaxFiles 个，返回时按照文件 id 从小到大排序
+func (db *DB) pickCompactFiles() ([]*data.DataFile, error) {
+	type candidate struct {
+		dataFile *data.DataFile
+		ratio    float32
+	}
+	var candidates []candidate
+	for fid, dataFile := range db.olderFiles {
+		deadBytes := db.fileDeadBytes[fid]
+		if deadBytes <= 0 {
+			continue
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			continue
+		}
+		ratio := float32(deadBytes) / float32(size)
+		if ratio >= db.options.DataFileCompactRatio {
+			candidates = append(candidates, candidate{dataFile: dataFile, ratio: ratio})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ratio > candidates[j].ratio
+	})
+	if db.options.CompactMaxFiles > 0 && len(candidates) > db.options.CompactMaxFiles {
+		candidates = candidates[:db.options.CompactMaxFiles]
+	}
+
+	compactFiles := make([]*data.DataFile, 0, len(candidates))
+	for _, c := range candidates {
+		compactFiles = append(compactFiles, c.dataFile)
+	}
+	sort.Slice(compactFiles, func(i, j int) bool {
+		return compactFiles[i].FileId < compactFiles[j].FileId
+	})
+	return compactFiles, nil
+}
+
+// compactDataFile 将一个旧数据文件中的有效数据重写到活跃文件中，然后删除这个文件
+func (db *DB) compactDataFile(dataFile *data.DataFile) error {
+	fileId := dataFile.FileId
+
+	// 存在更早的数据文件时，删除标记需要保留，否则重启后更早文件中的数据会重新生效
+	db.mu.RLock()
+	hasOlderFiles := false
+	for fid := range db.olderFiles {
+		if fid < fileId {
+			hasOlderFiles = true
+			break
+		}
+	}
+	db.mu.RUnlock()
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		realKey, seqNo := parseLogRcordKeyWithSeqNo(logRecord.Key)
+		// 文件开头是事务数据，说明这个事务可能是从上一个文件开始的
+		// 删除这个文件会丢掉事务完成的标识，导致上一个文件中的事务数据在重启后无法生效，因此跳过这个文件
+		if offset == 0 && seqNo != nonTransactionSeqNo && hasOlderFiles {
+			return nil
+		}
+
+		// 每条记录单独加锁，避免长时间阻塞读写
+		db.mu.Lock()
+		if db.closed.Load() {
+			err = ErrDatabaseClosed
+		} else {
+			err = db.compactLogRecord(fileId, offset, realKey, logRecord, hasOlderFiles)
+		}
+		db.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		offset += size
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	// 先持久化重写的数据，再删除旧文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	// 先从 MANIFEST 中去掉这个文件，删除前发生崩溃时，下次启动会清理掉这个文件
+	delete(db.olderFiles, fileId)
+	if err := db.saveManifest(); err != nil {
+		db.olderFiles[fileId] = dataFile
+		return err
+	}
+	db.publishFiles()
+	if err := os.Remove(data.GetDataFileName(db.options.DirPath, fileId)); err != nil {
+		return err
+	}
+	if err := removeHintFile(db.options.DirPath, fileId); err != nil {
+		return err
+	}
+	if err := db.invalidateIndexCheckpoint(); err != nil {
+		return err
+	}
+	// 文件中的数据此时已经全部是无效数据了，随文件一起回收
+	db.reclaimSize -= db.fileDeadBytes[fileId]
+	delete(db.fileDeadBytes, fileId)
+	return db.retireDataFile(dataFile)
+}
+
+// compactLogRecord 处理旧数据文件中的一条记录，需要持有互斥锁
+// 有效数据重写到活跃文件并原地更新索引，无效数据直接丢弃
+func (db *DB) compactLogRecord(fileId uint32, offset int64, realKey []byte,
+	logRecord *data.LogRecord, hasOlderFiles bool) error {
+	// 事务完成的标识不需要重写，重写后的数据都不再属于事务
+	if logRecord.Type == data.LogRecordTxnFinished {
+		return nil
+	}
+
+	// 等待持久化的写入在活跃文件中，比重写的数据更新，重写时需要以它为准
+	pos := db.latestPos(realKey)
+	isLive := pos != nil && pos.Fid == fileId && pos.Offset == offset
+
+	switch {
+	case isLive && !isExpired(pos, time.Now().UnixNano()):
+		// 有效数据，重写时清除事务序列号
+		newPos, err := db.appendLogRecord(&data.LogRecord{
+			Key:         logRecordKeyWithSeq(realKey, nonTransactionSeqNo),
+			Value:       logRecord.Value,
+			Type:        data.LogRecordNormal,
+			Expire:      logRecord.Expire,
+			Compression: logRecord.Compression,
+		})
+		if err != nil {
+			return err
+		}
+		db.index.Put(realKey, newPos)
+		db.markReclaimable(pos)
+	case isLive:
+		// 已经过期的数据，从索引中删除
+		if _, ok := db.index.Delete(realKey); !ok {
+			return ErrIndexUpdateFailed
+		}
+		db.markReclaimable(pos)
+		if hasOlderFiles {
+			return db.appendCompactTombstone(realKey)
+		}
+	case logRecord.Type == data.LogRecordDeleted && pos == nil && hasOlderFiles:
+		// key 已经被删除，保留删除标记
+		return db.appendCompactTombstone(realKey)
+	}
+	return nil
+}
+
+// appendCompactTombstone 在活跃文件中重新写入一条删除标记，需要持有互斥锁
+func (db *DB) appendCompactTombstone(key []byte) error {
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type: data.LogRecordDeleted,
+	})
+	if err != nil {
+		return err
+	}
+	db.markReclaimable(pos)
+	return nil
+}
@@ -0,0 +1,103 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空数据库
+	err = db.Compact()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 删除前面的大部分数据，使前几个文件的无效数据占比达到阈值
+	for i := 0; i < 800; i++ {
+		if i%10 == 0 {
+			continue
+		}
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	fileNum := len(db.olderFiles)
+	reclaimSize := db.reclaimSize
+	err = db.Compact()
+	assert.Nil(t, err)
+	assert.Less(t, len(db.olderFiles), fileNum)
+	assert.Less(t, db.reclaimSize, reclaimSize)
+
+	// 数据库运行期间就可以读取到正确的数据
+	checkData := func(db *DB) {
+		for i := 0; i < 2000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			if i < 800 && i%10 != 0 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+	}
+	checkData(db)
+
+	// 重启之后数据依然正确
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	checkData(db2)
+}
+
+func TestDB_Compact_PinnedFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-pinned")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Compact()
+	assert.Nil(t, err)
+	assert.NotEqual(t, 0, len(db.obsoleteFiles))
+
+	// 文件已经被删除，但快照依然可以读取
+	for i := 0; i < 1000; i++ {
+		val, err := snap.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+
+	// 快照释放后关闭被删除的文件
+	snap.Release()
+	assert.Equal(t, 0, len(db.obsoleteFiles))
+}
@@ -0,0 +1,66 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Compression(t *testing.T) {
+	for _, compression := range []CompressionType{SnappyCompression, DeflateCompression} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+		opts.DirPath = dir
+		opts.DataFileSize = 1024 * 1024
+		opts.DataFileMergeRatio = 0
+		opts.Compression = compression
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		value := func(i int) []byte {
+			return bytes.Repeat(utils.GetTestKey(i), 50)
+		}
+		for i := 0; i < 1000; i++ {
+			err := db.Put(utils.GetTestKey(i), value(i))
+			assert.Nil(t, err)
+		}
+		// 小于阈值的数据不压缩
+		err = db.Put([]byte("small"), []byte("small value"))
+		assert.Nil(t, err)
+		size := db.activeFile.WriteOff
+		assert.Less(t, size*5, int64(1000*len(value(0))))
+
+		checkData := func(db *DB) {
+			for i := 0; i < 1000; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, value(i), val)
+			}
+			val, err := db.Get([]byte("small"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("small value"), val)
+		}
+		checkData(db)
+
+		// 关闭压缩之后，之前压缩的数据依然可以读取，新的数据不再压缩
+		assert.Nil(t, db.Close())
+		opts.Compression = NoCompression
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		checkData(db)
+		err = db.Put([]byte("raw"), value(0))
+		assert.Nil(t, err)
+		assert.Greater(t, db.activeFile.WriteOff-size, int64(len(value(0))))
+
+		// merge 时保留已经压缩的数据
+		assert.Nil(t, db.Merge())
+		checkData(db)
+		val, err := db.Get([]byte("raw"))
+		assert.Nil(t, err)
+		assert.Equal(t, value(0), val)
+		destroyDB(db)
+	}
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidBlobRef = errors.New("invalid blob reference")
+)
+
+// blobChunkedMarker 分块存储的 blob 引用以负数开头，单个 blob 的位置编码以非负的文件 id 开头，两者不会混淆
+const blobChunkedMarker = -1
+
+// BlobRef 数据文件记录中保存的 blob 引用
+// 普通写入的较大 value 存储在一个 blob 中，流式写入的 value 按照固定大小分块，每块存储在一个 blob 中
+type BlobRef struct {
+	Chunks    []*LogRecordPos // 每个 blob 在 blob 文件中的位置
+	ChunkSize int64           // 除最后一块以外，每块 value 的长度，0 表示 value 存储在一个 blob 中
+	ValueSize int64           // 分块存储时 value 的总长度
+}
+
+// Chunked 判断 value 是否分块存储
+func (ref *BlobRef) Chunked() bool {
+	return ref.ChunkSize > 0
+}
+
+// ChunkLen 第 i 块 value 的长度
+func (ref *BlobRef) ChunkLen(i int) int64 {
+	if i == len(ref.Chunks)-1 {
+		return ref.ValueSize - int64(i)*ref.ChunkSize
+	}
+	return ref.ChunkSize
+}
+
+// EncodeBlobRef 编码 blob 引用，只有一个 blob 时和 LogRecordPos 的编码相同
+// 分块存储时的编码为【marker valueSize chunkSize chunkCount (fid offset size)...】，都是变长的
+func EncodeBlobRef(ref *BlobRef) []byte {
+	if !ref.Chunked() {
+		return EncodeLogRecordPos(ref.Chunks[0])
+	}
+	buf := make([]byte, binary.MaxVarintLen64*4+len(ref.Chunks)*(binary.MaxVarintLen32*2+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutVarint(buf[index:], blobChunkedMarker)
+	index += binary.PutVarint(buf[index:], ref.ValueSize)
+	index += binary.PutVarint(buf[index:], ref.ChunkSize)
+	index += binary.PutVarint(buf[index:], int64(len(ref.Chunks)))
+	for _, chunk := range ref.Chunks {
+		index += binary.PutVarint(buf[index:], int64(chunk.Fid))
+		index += binary.PutVarint(buf[index:], chunk.Offset)
+		index += binary.PutVarint(buf[index:], int64(chunk.Size))
+	}
+	return buf[:index]
+}
+
+// DecodeBlobRef 解码 blob 引用
+func DecodeBlobRef(buf []byte) (*BlobRef, error) {
+	var index = 0
+	next := func() (int64, error) {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return 0, ErrInvalidBlobRef
+		}
+		index += n
+		return v, nil
+	}
+
+	marker, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if marker >= 0 {
+		return &BlobRef{Chunks: []*LogRecordPos{DecodeLogRecordPos(buf)}}, nil
+	}
+	if marker != blobChunkedMarker {
+		return nil, ErrInvalidBlobRef
+	}
+
+	var values [3]int64
+	for i := range values {
+		if values[i], err = next(); err != nil {
+			return nil, err
+		}
+	}
+	ref := &BlobRef{ValueSize: values[0], ChunkSize: values[1]}
+	count := values[2]
+	// 除最后一块以外每块都是满的，块数由 value 的总长度决定
+	if ref.ChunkSize <= 0 || ref.ValueSize <= 0 || count > int64(len(buf)) ||
+		count != (ref.ValueSize+ref.ChunkSize-1)/ref.ChunkSize {
+		return nil, ErrInvalidBlobRef
+	}
+	ref.Chunks = make([]*LogRecordPos, 0, count)
+	for i := int64(0); i < count; i++ {
+		var chunk [3]int64
+		for j := range chunk {
+			if chunk[j], err = next(); err != nil {
+				return nil, err
+			}
+		}
+		ref.Chunks = append(ref.Chunks, &LogRecordPos{Fid: uint32(chunk[0]), Offset: chunk[1], Size: uint32(chunk[2])})
+	}
+	return ref, nil
+}
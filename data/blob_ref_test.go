@@ -0,0 +1,39 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlobRef(t *testing.T) {
+	// 单个 blob 的引用和 LogRecordPos 的编码相同
+	pos := &LogRecordPos{Fid: 3, Offset: 1024, Size: 4096}
+	ref, err := DecodeBlobRef(EncodeLogRecordPos(pos))
+	assert.Nil(t, err)
+	assert.False(t, ref.Chunked())
+	assert.Equal(t, pos, ref.Chunks[0])
+	assert.Equal(t, EncodeLogRecordPos(pos), EncodeBlobRef(ref))
+
+	chunked := &BlobRef{
+		Chunks: []*LogRecordPos{
+			{Fid: 0, Offset: 0, Size: 1100},
+			{Fid: 0, Offset: 1100, Size: 1100},
+			{Fid: 1, Offset: 0, Size: 600},
+		},
+		ChunkSize: 1024,
+		ValueSize: 2548,
+	}
+	buf := EncodeBlobRef(chunked)
+	ref, err = DecodeBlobRef(buf)
+	assert.Nil(t, err)
+	assert.True(t, ref.Chunked())
+	assert.Equal(t, chunked, ref)
+	assert.Equal(t, int64(1024), ref.ChunkLen(1))
+	assert.Equal(t, int64(500), ref.ChunkLen(2))
+
+	// 不完整的编码
+	_, err = DecodeBlobRef(buf[:len(buf)-1])
+	assert.Equal(t, ErrInvalidBlobRef, err)
+	_, err = DecodeBlobRef(nil)
+	assert.Equal(t, ErrInvalidBlobRef, err)
+}
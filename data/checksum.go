@@ -0,0 +1,124 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+)
+
+var (
+	ErrUnknownChecksum = errors.New("unknown checksum type")
+)
+
+type ChecksumType = byte
+
+const (
+	// ChecksumCRC32 CRC32（IEEE 多项式），旧版本的数据都使用这种校验方式
+	ChecksumCRC32 ChecksumType = iota
+
+	// ChecksumCRC32C CRC32C（Castagnoli 多项式），在支持 SSE4.2 或 ARMv8 CRC 指令的 CPU 上使用硬件加速
+	ChecksumCRC32C
+
+	// ChecksumXXHash64 64 位的 xxHash，比 CRC32 更不容易发生碰撞，纯软件实现的速度也很快
+	ChecksumXXHash64
+)
+
+// checksumTrailerSize 64 位校验值的高 32 位存储在记录的末尾，低 32 位和 CRC32 一样存储在记录开头
+const checksumTrailerSize = 4
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// trailerSize 校验值在记录末尾额外占用的长度
+func trailerSize(typ ChecksumType) int64 {
+	if typ == ChecksumXXHash64 {
+		return checksumTrailerSize
+	}
+	return 0
+}
+
+// checksum 使用指定的算法计算 header（不包括开头的校验值）和 key value 部分的校验值
+func checksum(typ ChecksumType, header, payload []byte) (uint64, error) {
+	switch typ {
+	case ChecksumCRC32:
+		return uint64(crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, payload)), nil
+	case ChecksumCRC32C:
+		return uint64(crc32.Update(crc32.Checksum(header, castagnoliTable), castagnoliTable, payload)), nil
+	case ChecksumXXHash64:
+		buf := make([]byte, len(header)+len(payload))
+		copy(buf, header)
+		copy(buf[len(header):], payload)
+		return xxhash64(buf), nil
+	default:
+		return 0, ErrUnknownChecksum
+	}
+}
+
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 计算种子为 0 的 XXH64 值
+func xxhash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for len(b) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+			b = b[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
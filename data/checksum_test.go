@@ -0,0 +1,64 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	assert.Equal(t, uint64(0xef46db3751d8e999), xxhash64([]byte("")))
+	assert.Equal(t, uint64(0xd24ec4f1a98c6e5b), xxhash64([]byte("a")))
+	assert.Equal(t, uint64(0x44bc2cf5ad770999), xxhash64([]byte("abc")))
+	assert.Equal(t, uint64(0xfbcea83c8a378bf1), xxhash64([]byte("Nobody inspects the spammish repetition")))
+}
+
+func TestDataFile_Checksum(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-checksum")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+
+	// 不同校验算法的记录可以写在同一个文件中
+	var offsets []int64
+	for _, typ := range []ChecksumType{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+		codec := &Codec{Checksum: typ}
+		encRecord, size, err := codec.EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(encRecord)), size)
+		offsets = append(offsets, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	endOffset := dataFile.WriteOff
+	assert.Nil(t, dataFile.Write((&Codec{Checksum: ChecksumXXHash64}).EncodeEndOfFile()))
+	// 结束标识之后的填充
+	assert.Nil(t, dataFile.Write(make([]byte, 64)))
+
+	for _, offset := range offsets {
+		record, _, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("bitcask-go"), record.Value)
+		assert.False(t, dataFile.IsEndOfFile(offset))
+	}
+	_, _, err = dataFile.ReadLogRecord(endOffset)
+	assert.Equal(t, io.EOF, err)
+	assert.True(t, dataFile.IsEndOfFile(endOffset))
+
+	// 全零的数据不再当作文件末尾，而是损坏的记录
+	_, _, err = dataFile.ReadLogRecord(dataFile.WriteOff - 32)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 64 位校验值的高位损坏
+	encRecord, size, err := (&Codec{Checksum: ChecksumXXHash64}).EncodeLogRecord(&LogRecord{Key: []byte("name")})
+	assert.Nil(t, err)
+	encRecord[size-1] ^= 0xff
+	dataFile, err = OpenDataFile(dir, 1, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRecord))
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, dataFile.Close())
+}
@@ -0,0 +1,47 @@
+package data
+
+// Codec 编码写入文件的 LogRecord，决定使用的校验算法以及是否加密
+// 为空时使用 CRC32 校验且不加密，编码出的记录和旧版本完全一样
+type Codec struct {
+	Checksum ChecksumType // 写入时使用的校验算法，读取时根据每条记录中的标识选择
+	Keyring  *Keyring     // 加密和解密使用的密钥，为空表示不加密
+}
+
+// checksumType 写入时使用的校验算法
+func (c *Codec) checksumType() ChecksumType {
+	if c == nil {
+		return ChecksumCRC32
+	}
+	return c.Checksum
+}
+
+// keyring 加密和解密使用的密钥
+func (c *Codec) keyring() *Keyring {
+	if c == nil {
+		return nil
+	}
+	return c.Keyring
+}
+
+// EncodeLogRecord 编码 LogRecord，配置了密钥时使用当前密钥加密
+func (c *Codec) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64, error) {
+	return encodeLogRecord(logRecord, c.checksumType(), c.keyring())
+}
+
+// EncodeHintRecord 编码数据文件 hint 中的一条记录
+// 保留原记录的 key（包括事务序列号）和类型，value 为位置索引，加载时可以和读取数据文件一样处理事务和删除标记
+func (c *Codec) EncodeHintRecord(key []byte, typ LogRecordType, pos *LogRecordPos) ([]byte, error) {
+	encRecord, _, err := c.EncodeLogRecord(&LogRecord{
+		Key:   key,
+		Value: EncodeLogRecordPos(pos),
+		Type:  typ,
+	})
+	return encRecord, err
+}
+
+// EncodeEndOfFile 编码文件结束标识，标识之后的数据都是填充，读取时当作文件末尾
+// 结束标识中没有 key 和 value，不需要加密
+func (c *Codec) EncodeEndOfFile() []byte {
+	encRecord, _, _ := encodeLogRecord(&LogRecord{Type: LogRecordEndOfFile}, c.checksumType(), nil)
+	return encRecord
+}
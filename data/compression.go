@@ -0,0 +1,87 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown compression type")
+	ErrCorruptedValue     = errors.New("compressed value is corrupted")
+)
+
+type CompressionType = byte
+
+const (
+	// CompressionNone value 没有压缩
+	CompressionNone CompressionType = iota
+
+	// CompressionSnappy snappy 格式的 LZ 压缩，速度快，压缩率一般
+	CompressionSnappy
+
+	// CompressionDeflate DEFLATE 压缩，速度较慢，压缩率更高
+	CompressionDeflate
+
+	// CompressionBlobRef value 存储在 blob 文件中，记录中的 value 是 blob 在 blob 文件中的位置，实际的 value 按照 blob 记录自身的压缩方式解压
+	CompressionBlobRef
+)
+
+var (
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+	flateReaderPool = sync.Pool{New: func() interface{} {
+		return flate.NewReader(nil)
+	}}
+)
+
+// CompressValue 使用指定的方式压缩 value
+func CompressValue(typ CompressionType, value []byte) ([]byte, error) {
+	switch typ {
+	case CompressionNone:
+		return value, nil
+	case CompressionSnappy:
+		return snappyEncode(value), nil
+	case CompressionDeflate:
+		var buf bytes.Buffer
+		w := flateWriterPool.Get().(*flate.Writer)
+		defer flateWriterPool.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// DecompressValue 解压使用指定方式压缩的 value
+func DecompressValue(typ CompressionType, buf []byte) ([]byte, error) {
+	switch typ {
+	case CompressionNone:
+		return buf, nil
+	case CompressionSnappy:
+		return snappyDecode(buf)
+	case CompressionDeflate:
+		r := flateReaderPool.Get().(io.ReadCloser)
+		defer flateReaderPool.Put(r)
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(buf), nil); err != nil {
+			return nil, err
+		}
+		value, err := io.ReadAll(r)
+		if err != nil {
+			return nil, ErrCorruptedValue
+		}
+		return value, nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressValue(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var repeated bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		repeated.WriteString(`{"name":"bitcask","id":`)
+		repeated.WriteString(string(rune('0' + i%10)))
+		repeated.WriteString(`,"tags":["kv","storage"]}`)
+	}
+	values := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("short value"),
+		bytes.Repeat([]byte("a"), 1000),
+		random,
+		repeated.Bytes(),
+	}
+
+	for _, typ := range []CompressionType{CompressionNone, CompressionSnappy, CompressionDeflate} {
+		for _, value := range values {
+			compressed, err := CompressValue(typ, value)
+			assert.Nil(t, err)
+			decompressed, err := DecompressValue(typ, compressed)
+			assert.Nil(t, err)
+			assert.Equal(t, len(value), len(decompressed))
+			assert.True(t, bytes.Equal(value, decompressed))
+		}
+		// 可压缩的数据
+		compressed, err := CompressValue(typ, repeated.Bytes())
+		assert.Nil(t, err)
+		if typ != CompressionNone {
+			assert.Less(t, len(compressed)*5, repeated.Len())
+		}
+	}
+
+	_, err := CompressValue(CompressionDeflate+1, []byte("a"))
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestDecompressValue_Corrupted(t *testing.T) {
+	value := bytes.Repeat([]byte("bitcask-go-value"), 100)
+	for _, typ := range []CompressionType{CompressionSnappy, CompressionDeflate} {
+		compressed, err := CompressValue(typ, value)
+		assert.Nil(t, err)
+		_, err = DecompressValue(typ, compressed[:len(compressed)/2])
+		assert.NotNil(t, err)
+	}
+
+	// 复制的偏移量超出了已经解码的数据
+	_, err := snappyDecode([]byte{10, 0x02 | 3<<2, 0x05, 0x00})
+	assert.Equal(t, ErrCorruptedValue, err)
+	// 长度不匹配
+	_, err = snappyDecode([]byte{10, 0x00, 'a'})
+	assert.Equal(t, ErrCorruptedValue, err)
+}
@@ -2,22 +2,29 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync/atomic"
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC    = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidHeader = errors.New("invalid log record header, log record maybe corrupted")
 )
 
 const (
-	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
-	MergeFinishedFileName = "merge-finished"
-	SeqNoFileName         = "seq-no"
+	DataFileNameSuffix      = ".data"
+	HintFileNameSuffix      = ".hint"
+	BlobFileNameSuffix      = ".blob"
+	HintFileName            = "hint-index"
+	MergeFinishedFileName   = "merge-finished"
+	SeqNoFileName           = "seq-no"
+	IndexCheckpointFileName = "index-checkpoint"
+	ManifestFileName        = "MANIFEST"
 )
 
 // DataFile 数据文件
@@ -25,26 +32,53 @@ type DataFile struct {
 	FileId    uint32        // 当前文件 id
 	WriteOff  int64         // 文件写偏移量，当前写到了哪个位置
 	IoManager fio.IOManager // io 管理接口，可以调用用来进行 io 操作
+	Codec     *Codec        // 编码记录使用的校验算法和密钥，为空表示使用 CRC32 校验且不加密
+	refs      int64         // 引用计数，最高位标识文件已经被删除，原子操作
 }
 
+// fileRetiredFlag 引用计数中标识文件已经被删除的位
+const fileRetiredFlag int64 = 1 << 62
+
 // OpenDataFile 根据目录和文件 ID，打开文件并构造 DataFile
 func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDataFileName(dirPath, fileId)
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// OpenBlobFile 打开存储较大 value 的 blob 文件
+func OpenBlobFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	return newDataFile(GetBlobFileName(dirPath, fileId), fileId, ioType)
+}
+
 // OpenHintFile 打开 Hint 索引文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenDataHintFile 打开数据文件对应的 hint 文件
+func OpenDataHintFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetHintFileName(dirPath, fileId), fileId, fio.StandardFIO)
+}
+
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenIndexCheckpointFile 打开保存内存索引 checkpoint 的文件
+func OpenIndexCheckpointFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexCheckpointFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
+// OpenManifestFile 打开记录数据目录格式版本和数据文件列表的 MANIFEST 文件
+func OpenManifestFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, ManifestFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 // OpenSeqNoFile 打开标识当前事务序列号的文件
 func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
@@ -68,6 +102,44 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+// GetHintFileName 获取数据文件对应的 hint 文件名称
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+// GetBlobFileName 获取 blob 文件名称
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+// Acquire 增加文件的引用计数，释放之前文件不会被关闭，文件已经被删除时返回 false
+func (df *DataFile) Acquire() bool {
+	for {
+		refs := atomic.LoadInt64(&df.refs)
+		if refs&fileRetiredFlag != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&df.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// Release 释放文件的引用，返回 true 表示文件已经被删除并且没有其他引用，调用方需要关闭文件
+func (df *DataFile) Release() bool {
+	return atomic.AddInt64(&df.refs, -1) == fileRetiredFlag
+}
+
+// Retire 标识文件已经被删除，之后的 Acquire 都会失败，返回 true 表示文件没有被引用，调用方可以立即关闭文件
+func (df *DataFile) Retire() bool {
+	return atomic.AddInt64(&df.refs, fileRetiredFlag) == fileRetiredFlag
+}
+
+// Refs 返回文件当前的引用计数
+func (df *DataFile) Refs() int64 {
+	return atomic.LoadInt64(&df.refs) &^ fileRetiredFlag
+}
+
 // Sync 数据文件持久化
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
@@ -88,8 +160,26 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
-// ReadLogRecord 根据偏移量读取 LogRecord
+// ReadLogRecord 根据偏移量读取 LogRecord，读到文件末尾或者文件结束标识时返回 io.EOF
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	logRecord, size, err := df.readLogRecord(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if logRecord.Type == LogRecordEndOfFile {
+		return nil, 0, io.EOF
+	}
+	return logRecord, size, nil
+}
+
+// IsEndOfFile 判断 offset 处是否为完整的文件结束标识，用于区分正常结束的文件和尾部不完整的文件
+func (df *DataFile) IsEndOfFile(offset int64) bool {
+	logRecord, _, err := df.readLogRecord(offset)
+	return err == nil && logRecord.Type == LogRecordEndOfFile
+}
+
+// readLogRecord 根据偏移量读取 LogRecord，包括文件结束标识
+func (df *DataFile) readLogRecord(offset int64) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
@@ -107,40 +197,62 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
-	header, headerSize := decodeLogRecordHeader(headerBuf)
+	header, headerSize, err := decodeLogRecordHeader(headerBuf)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	// 读到了文件末的情况
 	if header == nil {
 		return nil, 0, io.EOF
 	}
-	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
-		return nil, 0, io.EOF
-	}
 
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire, Compression: header.compression}
 
-	// 取出对应的 key 和 value 的长度
+	// 取出对应的 key 和 value 的长度，加密的记录还包括 nonce 和认证标签
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
-	recordSize := headerSize + keySize + valueSize
+	payloadSize := keySize + valueSize
+	if header.encrypted {
+		payloadSize += encryptOverhead
+	}
+	recordSize := headerSize + payloadSize + trailerSize(header.checksum)
 
-	// 开始读取实际存储的 key 和 value 数据
-	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+	// 记录超出了文件末尾，说明数据只写了一部分
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
+
+	// 开始读取实际存储的 key 和 value 数据，以及末尾的校验值高位
+	var kvBuf []byte
+	if recordSize > headerSize {
+		kvBuf, err = df.readNBytes(recordSize-headerSize, offset+headerSize)
 		if err != nil {
 			return nil, 0, err
 		}
-
-		// 解出 key 和 value
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
 	}
 
-	// 校验数据有效性
-	// 截取 Header 的 [crc32.Size:headerSize] 部分是因为，要排除 crc，以及取实际的 Header 长度，keySize valueSize 是变长的
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
+	// 校验数据有效性，校验值是对加密后的数据计算的，没有密钥也能发现数据损坏
+	// 截取 Header 的 [crc32.Size:headerSize] 部分是因为，要排除校验值，以及取实际的 Header 长度，keySize valueSize 是变长的
+	sum, err := checksum(header.checksum, headerBuf[crc32.Size:headerSize], kvBuf[:payloadSize])
+	if err != nil || uint32(sum) != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	if trailerSize(header.checksum) > 0 && uint32(sum>>32) != binary.LittleEndian.Uint32(kvBuf[payloadSize:]) {
 		return nil, 0, ErrInvalidCRC
 	}
+	kvBuf = kvBuf[:payloadSize]
+
+	if header.encrypted {
+		if kvBuf, err = df.Codec.keyring().open(header.keyId, headerBuf[crc32.Size:headerSize], kvBuf); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// 解出 key 和 value
+	if keySize > 0 || valueSize > 0 {
+		logRecord.Key = kvBuf[:keySize]
+		logRecord.Value = kvBuf[keySize:]
+	}
 
 	return logRecord, recordSize, nil
 }
@@ -158,7 +270,10 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _, err := df.Codec.EncodeLogRecord(record)
+	if err != nil {
+		return err
+	}
 	return df.Write(encRecord)
 }
 
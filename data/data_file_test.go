@@ -110,3 +110,25 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_HintRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	defer os.RemoveAll(dir)
+	hintFile, err := OpenDataHintFile(dir, 3)
+	assert.Nil(t, err)
+	assert.NotNil(t, hintFile)
+
+	pos := &LogRecordPos{Fid: 3, Offset: 128, Size: 64, Expire: 1000}
+	var codec *Codec
+	encRecord, err := codec.EncodeHintRecord([]byte("name"), LogRecordDeleted, pos)
+	assert.Nil(t, err)
+	err = hintFile.Write(encRecord)
+	assert.Nil(t, err)
+
+	record, _, err := hintFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("name"), record.Key)
+	assert.Equal(t, LogRecordDeleted, record.Type)
+	assert.Equal(t, pos, DecodeLogRecordPos(record.Value))
+	assert.Nil(t, hintFile.Close())
+}
@@ -0,0 +1,131 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("the log record is encrypted but no encryption key is provided")
+	ErrDecryptFailed         = errors.New("failed to decrypt the log record, the encryption key is wrong")
+	ErrInvalidEncryptionKey  = errors.New("the encryption key must be 16, 24 or 32 bytes")
+)
+
+const (
+	encryptNonceSize = 12 // AES-GCM 标准的 nonce 长度
+	encryptTagSize   = 16 // AES-GCM 认证标签的长度
+
+	// encryptOverhead 加密后的数据比原始的 key + value 多出的长度
+	encryptOverhead = encryptNonceSize + encryptTagSize
+)
+
+// KeyProvider 提供加密数据使用的密钥，通过切换当前密钥 id 实现密钥轮换
+// 每条加密的记录都保存了密钥 id，轮换之后旧的数据依然使用原来的密钥解密，merge 时会使用当前密钥重新加密
+type KeyProvider interface {
+	// CurrentKeyId 加密新写入的数据使用的密钥 id
+	CurrentKeyId() uint32
+
+	// Key 根据密钥 id 获取密钥，长度为 16、24 或 32 字节，分别对应 AES-128、AES-192、AES-256
+	Key(keyId uint32) ([]byte, error)
+}
+
+// staticKeyProvider 只有一个密钥，密钥 id 为 0
+type staticKeyProvider struct {
+	key []byte
+}
+
+// NewStaticKeyProvider 使用固定的密钥，密钥 id 为 0
+func NewStaticKeyProvider(key []byte) KeyProvider {
+	return &staticKeyProvider{key: key}
+}
+
+func (p *staticKeyProvider) CurrentKeyId() uint32 {
+	return 0
+}
+
+func (p *staticKeyProvider) Key(keyId uint32) ([]byte, error) {
+	if keyId != 0 {
+		return nil, ErrDecryptFailed
+	}
+	return p.key, nil
+}
+
+// Keyring 使用 AES-GCM 加密和解密 LogRecord 的 key 和 value，缓存每个密钥 id 对应的加密实例
+type Keyring struct {
+	provider KeyProvider
+	mu       sync.RWMutex
+	aeads    map[uint32]cipher.AEAD
+}
+
+// NewKeyring 创建 Keyring，并检查当前密钥是否可用
+func NewKeyring(provider KeyProvider) (*Keyring, error) {
+	k := &Keyring{
+		provider: provider,
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+	if _, err := k.aead(provider.CurrentKeyId()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// aead 获取密钥 id 对应的加密实例
+func (k *Keyring) aead(keyId uint32) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, ok := k.aeads[keyId]
+	k.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, err := k.provider.Key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, ErrInvalidEncryptionKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.aeads[keyId] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// seal 加密 key + value，返回【nonce + 密文 + 认证标签】，header 作为附加数据一起认证
+func (k *Keyring) seal(keyId uint32, header, plaintext []byte) ([]byte, error) {
+	aead, err := k.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, encryptNonceSize, encryptNonceSize+len(plaintext)+encryptTagSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, buf[:encryptNonceSize], plaintext, header), nil
+}
+
+// open 解密 seal 生成的数据
+func (k *Keyring) open(keyId uint32, header, payload []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	aead, err := k.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, payload[:encryptNonceSize], payload[encryptNonceSize:], header)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// rotatingKeyProvider 测试密钥轮换使用的 KeyProvider
+type rotatingKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *rotatingKeyProvider) CurrentKeyId() uint32 {
+	return p.current
+}
+
+func (p *rotatingKeyProvider) Key(keyId uint32) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, ErrDecryptFailed
+	}
+	return key, nil
+}
+
+func TestDataFile_Encryption(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer os.RemoveAll(dir)
+	provider := &rotatingKeyProvider{
+		current: 1,
+		keys:    map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)},
+	}
+	keyring, err := NewKeyring(provider)
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	codec := &Codec{Checksum: ChecksumCRC32C, Keyring: keyring}
+	dataFile.Codec = codec
+
+	rec1 := &LogRecord{Key: []byte("secret-key"), Value: []byte("secret-value"), Expire: 1000}
+	encRec1, size1, err := codec.EncodeLogRecord(rec1)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(encRec1, rec1.Key))
+	assert.False(t, bytes.Contains(encRec1, rec1.Value))
+	assert.Nil(t, dataFile.Write(encRec1))
+
+	// 轮换密钥之后写入的数据使用新的密钥
+	provider.keys[2] = bytes.Repeat([]byte("n"), 16)
+	provider.current = 2
+	rec2 := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+	encRec2, size2, err := codec.EncodeLogRecord(rec2)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRec2))
+
+	// 没有加密的数据可以和加密的数据共存
+	rec3 := &LogRecord{Key: []byte("plain"), Value: []byte("value")}
+	encRec3, _ := EncodeLogRecord(rec3)
+	assert.Nil(t, dataFile.Write(encRec3))
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1.Key, readRec1.Key)
+	assert.Equal(t, rec1.Value, readRec1.Value)
+	assert.Equal(t, rec1.Expire, readRec1.Expire)
+	assert.Equal(t, size1, readSize1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2.Key, readRec2.Key)
+	assert.Equal(t, LogRecordDeleted, readRec2.Type)
+	assert.Equal(t, size2, readSize2)
+	readRec3, _, err := dataFile.ReadLogRecord(size1 + size2)
+	assert.Nil(t, err)
+	assert.Equal(t, rec3.Value, readRec3.Value)
+
+	// 没有密钥
+	dataFile.Codec = nil
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	// 密钥错误
+	provider.keys[1] = bytes.Repeat([]byte("x"), 32)
+	wrongKeyring, err := NewKeyring(provider)
+	assert.Nil(t, err)
+	dataFile.Codec = &Codec{Keyring: wrongKeyring}
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrDecryptFailed, err)
+	assert.Nil(t, dataFile.Close())
+
+	// 密钥长度不合法
+	_, err = NewKeyring(NewStaticKeyProvider([]byte("short")))
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+}
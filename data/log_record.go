@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"math"
 )
 
 type LogRecordType = byte
@@ -11,6 +12,23 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordEndOfFile 文件结束标识，数据文件转换成旧文件时写在末尾，之后的数据都是填充
+	LogRecordEndOfFile
+)
+
+const (
+	// logRecordTypeMask type 字节的低位存储 LogRecord 的类型
+	logRecordTypeMask byte = 0x03
+	// logRecordCompressionMask type 字节的第 2、3 位存储 value 的压缩方式，或者标识 value 存储在 blob 文件中
+	logRecordCompressionMask  byte = 0x0c
+	logRecordCompressionShift      = 2
+	// logRecordEncryptedFlag type 字节的第 4 位标识该记录的 key 和 value 是否加密
+	logRecordEncryptedFlag byte = 0x10
+	// logRecordChecksumMask type 字节的第 5、6 位存储校验算法
+	logRecordChecksumMask  byte = 0x60
+	logRecordChecksumShift      = 5
+	// logRecordExpireFlag type 字节的最高位标识该记录是否携带过期时间
+	logRecordExpireFlag byte = 0x80
 )
 
 // LogRecordPos 数据内存索引，描述了数据在磁盘上的位置
@@ -18,6 +36,7 @@ type LogRecordPos struct {
 	Fid    uint32 // 文件 id，表示数据存在了磁盘上哪个文件中
 	Offset int64  // 偏移量，表示数据在文件中的位置
 	Size   uint32 // 标识数据在磁盘上的大小
+	Expire int64  // 过期时间（UnixNano），0 表示永不过期
 }
 
 // TransactionRecord 暂存事务相关的数据
@@ -26,13 +45,14 @@ type TransactionRecord struct {
 	Pos    *LogRecordPos
 }
 
-// Header【crc type keySize valueSize】
+// Header【crc type keySize valueSize expire keyId】
 //
-//	【4B + 1B + 5B    + 5B      】
+//	【4B + 1B + 5B    + 5B      + 10B  + 5B  】
 //
-// crc 和 type 也都是定长，keySize、valueSize 是变长的
-// 变长 int32 的最大值为 5B
-const maxLogRecordHeaderSize = 4 + 1 + binary.MaxVarintLen32*2
+// crc 和 type 也都是定长，keySize、valueSize、expire、keyId 是变长的
+// 变长 int32 的最大值为 5B，变长 int64 的最大值为 10B
+// expire 只有在 type 字节中设置了过期标志位时才存在，keyId 只有在设置了加密标志位时才存在
+const maxLogRecordHeaderSize = 4 + 1 + binary.MaxVarintLen32*3 + binary.MaxVarintLen64
 
 // LogRecord 追加写到磁盘数据文件的日志记录
 // 先写磁盘数据文件，再更新内存索引
@@ -42,80 +62,171 @@ type LogRecord struct {
 
 	// 数据墓碑值，根据 bitcask 论文描述，数据删除后会进行标记，此处会标记为 LogRecordDeleted
 	Type LogRecordType
+	// 过期时间（UnixNano），0 表示永不过期
+	Expire int64
+	// Value 的压缩方式，读取时需要先解压
+	Compression CompressionType
 }
 
 type LogRecordHeader struct {
-	crc        uint32        // crc 校验值
-	recordType LogRecordType // LogRecord 的类型
-	keySize    uint32        // key 的长度
-	valueSize  uint32        // value 的长度
+	crc         uint32          // crc 校验值，64 位校验值的低 32 位
+	recordType  LogRecordType   // LogRecord 的类型
+	keySize     uint32          // key 的长度
+	valueSize   uint32          // value 的长度
+	expire      int64           // 过期时间
+	compression CompressionType // value 的压缩方式
+	encrypted   bool            // key 和 value 是否加密
+	keyId       uint32          // 加密使用的密钥 id
+	checksum    ChecksumType    // 校验算法
 }
 
-// EncodeLogRecord 将 LogRecord 编码成字节数组
+// EncodeLogRecord 将 LogRecord 编码成字节数组，使用 CRC32 校验，不加密
 // 返回字节数组 和 长度
-// +-------------+-------------+-------------+-------------+-------------+-------------+
-// | crc 校验值   +   type 类型  +  key size   + value size  +     key     +    value    +
-// +-------------+-------------+-------------+-------------+-------------+-------------+
+// +-------------+-------------+-------------+-------------+-------------+-------------+-------------+-------------+-------------+
+// | crc 校验值   +   type 类型  +  key size   + value size  +   expire    +   key id    +     key     +    value    +  校验值高位  +
+// +-------------+-------------+-------------+-------------+-------------+-------------+-------------+-------------+-------------+
 //
-//	4 bytes       1 byte      变长（最大 5）  变长（最大 5）      变长           变长
+//	4 bytes       1 byte      变长（最大 5）  变长（最大 5） 变长（最大 10，可选）  变长（最大 5，可选） 变长    变长   4 bytes（可选）
+//
+// 加密的记录中 key 和 value 部分替换为【nonce + key 和 value 的密文 + 认证标签】
+// 使用 64 位校验算法时，开头存储校验值的低 32 位，末尾存储高 32 位
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
+	encBytes, size, _ := encodeLogRecord(logRecord, ChecksumCRC32, nil)
+	return encBytes, size
+}
+
+// encodeLogRecord 编码 LogRecord，使用指定的算法计算校验值，keyring 不为空时使用当前密钥加密 key 和 value
+func encodeLogRecord(logRecord *LogRecord, checksumType ChecksumType, keyring *Keyring) ([]byte, int64, error) {
 	// 初始化一个 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
-	// 第五个字节存储 Type
-	header[4] = logRecord.Type
+	// 第五个字节存储 Type、value 的压缩方式以及校验算法
+	header[4] = logRecord.Type | (logRecord.Compression<<logRecordCompressionShift)&logRecordCompressionMask |
+		(checksumType<<logRecordChecksumShift)&logRecordChecksumMask
 	var index = 5
 
 	// 接下来写入 key size 和 value size
 	// 使用变长类型，可以节省空间
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	// 设置了过期时间的记录，在 type 字节中打上标记，并写入过期时间
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+
+	var payload []byte
+	if keyring != nil {
+		// 加密的记录在 type 字节中打上标记，并写入密钥 id，header 作为附加数据参与认证
+		keyId := keyring.provider.CurrentKeyId()
+		header[4] |= logRecordEncryptedFlag
+		index += binary.PutUvarint(header[index:], uint64(keyId))
+		plaintext := make([]byte, len(logRecord.Key)+len(logRecord.Value))
+		copy(plaintext, logRecord.Key)
+		copy(plaintext[len(logRecord.Key):], logRecord.Value)
+		var err error
+		if payload, err = keyring.seal(keyId, header[4:index], plaintext); err != nil {
+			return nil, 0, err
+		}
+	}
 
 	// 得到实际的 logRecord 编码成字节数组的长度
-	size := index + len(logRecord.Key) + len(logRecord.Value)
+	payloadSize := len(logRecord.Key) + len(logRecord.Value)
+	if payload != nil {
+		payloadSize = len(payload)
+	}
+	size := index + payloadSize + int(trailerSize(checksumType))
 	encBytes := make([]byte, size)
 
 	// 将 header 拷贝到 encBytes
 	copy(encBytes[:index], header[:index])
 
 	// 将 key 和 value 数据直接拷贝到字节数组，key 和 value 本身就是 []byte
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	if payload != nil {
+		copy(encBytes[index:], payload)
+	} else {
+		copy(encBytes[index:], logRecord.Key)
+		copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	}
 
-	// 对字节数组计算 crc，并存入前四个字节
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	binary.LittleEndian.PutUint32(encBytes[:4], crc)
+	// 对 header 和 key value 计算校验值，存入前四个字节，64 位校验值的高位存入末尾
+	sum, err := checksum(checksumType, encBytes[4:index], encBytes[index:index+payloadSize])
+	if err != nil {
+		return nil, 0, err
+	}
+	binary.LittleEndian.PutUint32(encBytes[:4], uint32(sum))
+	if trailerSize(checksumType) > 0 {
+		binary.LittleEndian.PutUint32(encBytes[index+payloadSize:], uint32(sum>>32))
+	}
 
 	// for test
-	//fmt.Printf("header length : %d, crc : %d\n", index, crc)
+	//fmt.Printf("header length : %d, crc : %d\n", index, sum)
 
-	return encBytes, int64(size)
+	return encBytes, int64(size), nil
 }
 
 // decodeLogRecordHeader 解码 Header
-func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
+// buf 在变长字段中间结束时说明数据只写了一部分，返回空的 Header；变长字段溢出或者长度超出范围时返回 ErrInvalidHeader
+func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64, error) {
 	if len(buf) <= 4 {
-		return nil, 0
+		return nil, 0, nil
 	}
 
 	header := &LogRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		crc:         binary.LittleEndian.Uint32(buf[:4]),
+		recordType:  buf[4] & logRecordTypeMask,
+		compression: (buf[4] & logRecordCompressionMask) >> logRecordCompressionShift,
+		checksum:    (buf[4] & logRecordChecksumMask) >> logRecordChecksumShift,
 	}
 
 	var index = 5
 
 	// 取 key size
 	keySize, n := binary.Varint(buf[index:])
+	if n <= 0 || keySize < 0 || keySize > math.MaxUint32 {
+		return decodeHeaderFailed(n)
+	}
 	header.keySize = uint32(keySize)
 	index += n
 
 	// 取 value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 || valueSize < 0 || valueSize > math.MaxUint32 {
+		return decodeHeaderFailed(n)
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
-	return header, int64(index)
+	// 取过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return decodeHeaderFailed(n)
+		}
+		header.expire = expire
+		index += n
+	}
+
+	// 取密钥 id
+	if buf[4]&logRecordEncryptedFlag != 0 {
+		keyId, n := binary.Uvarint(buf[index:])
+		if n <= 0 || keyId > math.MaxUint32 {
+			return decodeHeaderFailed(n)
+		}
+		header.encrypted = true
+		header.keyId = uint32(keyId)
+		index += n
+	}
+
+	return header, int64(index), nil
+}
+
+// decodeHeaderFailed 变长字段解码失败，n 为 0 表示数据不完整，否则表示数据已经损坏
+func decodeHeaderFailed(n int) (*LogRecordHeader, int64, error) {
+	if n == 0 {
+		return nil, 0, nil
+	}
+	return nil, 0, ErrInvalidHeader
 }
 
 // getLogRecordCRC 根据 LogRecord 中的 key value 和 header 计算 CRC
@@ -132,14 +243,17 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 }
 
 // EncodeLogRecordPos 对位置信息进行编码，生成字节数组
-// 位置信息只有 file id 、 offset 和 size
+// 位置信息有 file id 、 offset 、 size 和 expire
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 
 	// 写入 fid 和 offset
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	// 写入 size 和 expire，旧版本的编码中没有这两部分，解码时读到的是 0
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	index += binary.PutVarint(buf[index:], pos.Expire)
 	return buf[:index]
 }
 
@@ -150,10 +264,13 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+	expire, _ := binary.Varint(buf[index:])
 	return &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
 	}
 }
@@ -1,6 +1,7 @@
 package data
 
 import (
+  "bytes"
   "github.com/stretchr/testify/assert"
   "hash/crc32"
   "testing"
@@ -43,7 +44,8 @@ func TestDecodeLogRecordHeader(t *testing.T) {
   // header length : 7, crc : 2945958886
   // log_record_test.go:16: [230 195 151 175 0 8 6 110 97 109 101 89 114 97]
   headerBuf1 := []byte{230, 195, 151, 175, 0, 8, 6}
-  h1, size1 := decodeLogRecordHeader(headerBuf1)
+  h1, size1, err := decodeLogRecordHeader(headerBuf1)
+  assert.Nil(t, err)
   assert.NotNil(t, h1)
   assert.Equal(t, int64(7), size1)
   assert.Equal(t, uint32(2945958886), h1.crc)
@@ -54,7 +56,8 @@ func TestDecodeLogRecordHeader(t *testing.T) {
   //header length : 7, crc : 240712713
   //log_record_test.go:27: [9 252 88 14 0 8 0 110 97 109 101]
   headerBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
-  h2, size2 := decodeLogRecordHeader(headerBuf2)
+  h2, size2, err := decodeLogRecordHeader(headerBuf2)
+  assert.Nil(t, err)
   assert.NotNil(t, h2)
   assert.Equal(t, int64(7), size2)
   assert.Equal(t, uint32(240712713), h2.crc)
@@ -65,13 +68,25 @@ func TestDecodeLogRecordHeader(t *testing.T) {
   //header length : 7, crc : 1079355608
   //log_record_test.go:38: [216 168 85 64 1 8 6 110 97 109 101 89 114 97]
   headerBuf3 := []byte{216, 168, 85, 64, 1, 8, 6}
-  h3, size3 := decodeLogRecordHeader(headerBuf3)
+  h3, size3, err := decodeLogRecordHeader(headerBuf3)
+  assert.Nil(t, err)
   assert.NotNil(t, h3)
   assert.Equal(t, int64(7), size3)
   assert.Equal(t, uint32(1079355608), h3.crc)
   assert.Equal(t, LogRecordDeleted, h3.recordType)
   assert.Equal(t, uint32(4), h3.keySize)   // "name"
   assert.Equal(t, uint32(3), h3.valueSize) // "Yra"
+
+  // 变长字段溢出或者长度为负数，说明数据已经损坏
+  _, _, err = decodeLogRecordHeader(bytes.Repeat([]byte{0xff}, 40))
+  assert.Equal(t, ErrInvalidHeader, err)
+  _, _, err = decodeLogRecordHeader([]byte{0, 0, 0, 0, 0, 1, 6})
+  assert.Equal(t, ErrInvalidHeader, err)
+
+  // 变长字段不完整，说明数据只写了一部分
+  h4, _, err := decodeLogRecordHeader([]byte{0, 0, 0, 0, 0, 0x80})
+  assert.Nil(t, err)
+  assert.Nil(t, h4)
 }
 
 func TestGetlogRecordCRC(t *testing.T) {
@@ -102,3 +117,52 @@ func TestGetlogRecordCRC(t *testing.T) {
   crc = getLogRecordCRC(rec3, headerBuf3[crc32.Size:])
   assert.Equal(t, crc, uint32(1079355608))
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+  rec := &LogRecord{
+    Key:    []byte("name"),
+    Value:  []byte("Yra"),
+    Type:   LogRecordNormal,
+    Expire: 1700000000000000000,
+  }
+  res, n := EncodeLogRecord(rec)
+  assert.NotNil(t, res)
+
+  header, headerSize, err := decodeLogRecordHeader(res)
+  assert.Nil(t, err)
+  assert.NotNil(t, header)
+  assert.Equal(t, LogRecordNormal, header.recordType)
+  assert.Equal(t, rec.Expire, header.expire)
+  assert.Equal(t, n, headerSize+int64(len(rec.Key)+len(rec.Value)))
+
+  crc := getLogRecordCRC(rec, res[crc32.Size:headerSize])
+  assert.Equal(t, header.crc, crc)
+
+  // 位置信息中的过期时间
+  pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: rec.Expire}
+  assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+}
+
+func TestEncodeLogRecord_Compression(t *testing.T) {
+  rec := &LogRecord{
+    Key:         []byte("name"),
+    Value:       []byte("compressed"),
+    Type:        LogRecordNormal,
+    Expire:      1700000000000000000,
+    Compression: CompressionSnappy,
+  }
+  res, _ := EncodeLogRecord(rec)
+  header, _, err := decodeLogRecordHeader(res)
+  assert.Nil(t, err)
+  assert.Equal(t, LogRecordNormal, header.recordType)
+  assert.Equal(t, CompressionSnappy, header.compression)
+  assert.Equal(t, rec.Expire, header.expire)
+
+  // 删除标记不受压缩标识的影响
+  rec = &LogRecord{Key: []byte("name"), Type: LogRecordDeleted}
+  res, _ = EncodeLogRecord(rec)
+  header, _, err = decodeLogRecordHeader(res)
+  assert.Nil(t, err)
+  assert.Equal(t, LogRecordDeleted, header.recordType)
+  assert.Equal(t, CompressionNone, header.compression)
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"encoding/binary"
+)
+
+// snappy 块格式的编码和解码
+// 【解压后的长度（uvarint）】之后是若干个元素，每个元素的第一个字节是 tag，低两位表示元素的类型
+//
+//	00 字面量，tag 的高 6 位是长度 - 1，达到 60 时长度存储在之后的 1～4 个字节中
+//	01 复制，长度 4～11，偏移量小于 2048，偏移量的高 3 位存储在 tag 中，低 8 位在之后的 1 个字节
+//	10 复制，长度 1～64，偏移量存储在之后的 2 个字节中
+//	11 复制，长度 1～64，偏移量存储在之后的 4 个字节中
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01
+	snappyTagCopy2   = 0x02
+	snappyTagCopy4   = 0x03
+
+	snappyHashTableBits = 14
+	snappyMaxOffset     = 1<<16 - 1 // 只生成 1～2 字节偏移量的复制
+	snappyMinInputSize  = 17        // 太短的数据直接作为字面量
+)
+
+// snappyEncode 贪心地查找 4 字节的重复数据，编码成复制元素
+func snappyEncode(src []byte) []byte {
+	dst := make([]byte, 0, len(src)+len(src)/6+binary.MaxVarintLen32+5)
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	if len(src) < snappyMinInputSize {
+		return snappyEmitLiteral(dst, src)
+	}
+
+	// 哈希表中保存 4 字节数据最近一次出现的位置 + 1，0 表示没有出现过
+	var table [1 << snappyHashTableBits]int32
+	literalStart, s := 0, 0
+	for s+4 <= len(src) {
+		cur := binary.LittleEndian.Uint32(src[s:])
+		h := snappyHash(cur)
+		candidate := int(table[h]) - 1
+		table[h] = int32(s + 1)
+		if candidate < 0 || s-candidate > snappyMaxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != cur {
+			s++
+			continue
+		}
+
+		// 找到了重复数据，先写入之前的字面量，再尽量延长匹配的长度
+		dst = snappyEmitLiteral(dst, src[literalStart:s])
+		length := 4
+		for s+length < len(src) && src[candidate+length] == src[s+length] {
+			length++
+		}
+		dst = snappyEmitCopy(dst, s-candidate, length)
+		s += length
+		literalStart = s
+	}
+	return snappyEmitLiteral(dst, src[literalStart:])
+}
+
+func snappyHash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - snappyHashTableBits)
+}
+
+func snappyEmitLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+func snappyEmitCopy(dst []byte, offset, length int) []byte {
+	// 较长的匹配拆分成多个复制元素，保证最后一段的长度不少于 4
+	for length >= 68 {
+		dst = append(dst, 63<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|snappyTagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+}
+
+// snappyDecode 解码 snappy 块格式的数据，数据不完整或者不合法时返回 ErrCorruptedValue
+func snappyDecode(src []byte) ([]byte, error) {
+	decodedLen, n := binary.Uvarint(src)
+	if n <= 0 || decodedLen > uint64(len(src))*255+snappyMinInputSize {
+		return nil, ErrCorruptedValue
+	}
+	dst := make([]byte, 0, decodedLen)
+
+	s := n
+	for s < len(src) {
+		tag := src[s]
+		var length, offset int
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			x := uint32(tag >> 2)
+			s++
+			if x >= 60 {
+				extra := int(x - 59)
+				if s+extra > len(src) {
+					return nil, ErrCorruptedValue
+				}
+				x = 0
+				for i := 0; i < extra; i++ {
+					x |= uint32(src[s+i]) << (8 * i)
+				}
+				s += extra
+			}
+			length = int(x) + 1
+			if length <= 0 || s+length > len(src) || uint64(len(dst)+length) > decodedLen {
+				return nil, ErrCorruptedValue
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+		case snappyTagCopy1:
+			if s+2 > len(src) {
+				return nil, ErrCorruptedValue
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case snappyTagCopy2:
+			if s+3 > len(src) {
+				return nil, ErrCorruptedValue
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case snappyTagCopy4:
+			if s+5 > len(src) {
+				return nil, ErrCorruptedValue
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > decodedLen {
+			return nil, ErrCorruptedValue
+		}
+		// 复制的区域可能和正在写入的区域重叠，需要逐个字节复制
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != decodedLen {
+		return nil, ErrCorruptedValue
+	}
+	return dst, nil
+}
@@ -5,16 +5,18 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -24,33 +26,71 @@ const (
 
 // DB bitcask 存储引擎实例
 type DB struct {
-	fileIds        []int // 文件 id 列表，用于有序遍历，只能在加载索引时使用
-	mu             *sync.RWMutex
-	options        Options                   // 数据库配置项
-	activeFile     *data.DataFile            // 当前活跃数据文件，可以写
-	olderFiles     map[uint32]*data.DataFile // 旧的数据文件，只能读; 文件 id -> 数据文件
-	index          index.Indexer             // 内存索引
-	seqNo          uint64                    // 当前最新的事务序列号，全局递增
-	isMerging      bool                      // 标识当前是否正在进行 merge
-	seqNoFileExist bool                      // 存储事务序列号的文件是否存在
-	isInitial      bool                      // 判断是否是第一次初始化此数据目录
-	fileLock       *flock.Flock              // 文件锁保证多进程间的互斥
-	bytesWrite     uint                      // 累计写了多少个字节
-	reclaimSize    int64                     // 表示有多少数据是无效的
+	fileIds           []int // 文件 id 列表，用于有序遍历，只能在加载索引时使用
+	mu                *sync.RWMutex
+	options           Options                              // 数据库配置项
+	activeFile        *data.DataFile                       // 当前活跃数据文件，可以写
+	olderFiles        map[uint32]*data.DataFile            // 旧的数据文件，只能读; 文件 id -> 数据文件
+	index             index.Indexer                        // 内存索引
+	seqNo             uint64                               // 当前最新的事务序列号，全局递增
+	isMerging         bool                                 // 标识当前是否正在进行 merge
+	seqNoFileExist    bool                                 // 存储事务序列号的文件是否存在
+	isInitial         bool                                 // 判断是否是第一次初始化此数据目录
+	fileLock          *flock.Flock                         // 文件锁保证多个写实例之间的互斥，只读实例为 nil
+	bytesWrite        uint                                 // 累计写了多少个字节
+	reclaimSize       int64                                // 表示有多少数据是无效的
+	fileDeadBytes     map[uint32]int64                     // 每个数据文件中无效数据的大小，用于挑选需要 compaction 的文件
+	activeHints       []byte                               // 活跃文件中数据的 hint 记录，活跃文件转换成旧文件时写入 hint 文件
+	indexEpoch        uint64                               // merge 或 compaction 改变数据位置的次数，用于判断拷贝的索引是否还能保存为 checkpoint
+	recoveryReport    *RecoveryReport                      // 启动时活跃文件尾部损坏数据的处理情况
+	manifest          *manifest                            // 数据目录的格式版本和有效的数据文件列表，旧目录在加载数据文件之前为空
+	codec             *data.Codec                          // 编码记录使用的校验算法和加密密钥
+	blobFiles         map[uint32]*data.DataFile            // 所有的 blob 文件，包括活跃 blob 文件
+	activeBlobFile    *data.DataFile                       // 当前写入的 blob 文件，为空时写入 blob 之前新建
+	nextBlobFileId    uint32                               // 下一个新建的 blob 文件使用的 id
+	blobUnsynced      bool                                 // 活跃 blob 文件中是否有还没有持久化的数据
+	streamingBlobs    map[uint32]int                       // 正在进行的流式写入已经写入了分块的 blob 文件，merge 时不能回收
+	writeSeq          uint64                               // 写入数据文件的记录数量，用于判断同步写入的数据是否已经持久化
+	groupCommit       *groupCommit                         // 合并并发的同步写入的持久化
+	obsoleteFiles     map[*data.DataFile]struct{}          // 已经被 merge 或 compaction 删除但仍被快照或迭代器引用的文件，引用释放后关闭
+	files             atomic.Pointer[fileSet]              // 当前发布的数据文件和 blob 文件集合，不持有互斥锁的读操作从中查找文件
+	fileSwaps         atomic.Uint64                        // merge 替换数据文件的次数，替换期间为奇数，用于判断不持有锁的读取是否读到了被替换的文件
+	activeTxns        int                                  // 正在进行中的交互式事务数量
+	txnWrites         map[string]uint64                    // 有事务进行时，记录 key 最近一次被修改时的序列号，用于冲突检测
+	txnWritesFloor    uint64                               // 冲突检测记录超过上限被清空时的序列号，在此之前开始并且读过数据的事务提交时视为冲突
+	deferredWrite     *unsyncedWrite                       // 正在执行的需要等待持久化的写操作，对索引的修改暂存在其中
+	unsyncedWrites    []*unsyncedWrite                     // 已经写入数据文件，等待持久化之后更新索引的写操作，按照写入序号排序
+	unsyncedIndex     map[string]*unsyncedIndexOp          // 每个 key 最近一次等待持久化的索引修改
+	autoMergeStop     chan struct{}                        // 通知后台自动 merge 协程退出
+	autoMergeDone     chan struct{}                        // 后台自动 merge 协程已经退出
+	autoMergeStopOnce sync.Once                            // 保证只通知一次后台自动 merge 协程退出
+	mergeStatus       MergeStatus                          // 后台自动 merge 的运行状态
+	pendingTxns       map[uint64][]*data.TransactionRecord // 只读模式下还没有读到完成标识的事务数据
+	mergeMark         os.FileInfo                          // 只读模式下最近一次全部加载时 merge 完成标识的文件信息，用于发现写实例进行的 merge
+	needReload        bool                                 // 只读模式下上一次加载失败，需要重新加载全部数据
+	closed            atomic.Bool                          // 数据库是否已经关闭，关闭之后的操作都返回 ErrDatabaseClosed
+	logger            Logger                               // 输出日志，没有配置时不输出
+	listener          EventListener                        // 接收内部事件的回调，没有配置时不处理
+	metrics           *metrics                             // 运行期间累计的统计指标
 }
 
 // Stat 存储引擎统计数据
 type Stat struct {
-	KeyNum          uint  // key 的总数量
-	DataFileNum     uint  // 磁盘上数据文件的数量
-	ReclaimableSize int64 // 可以通过 merge 回收的数据量，以字节为单位
-	DiskSize        int64 // 数据目录所占磁盘空间的大小
+	KeyNum          uint            // key 的总数量
+	DataFileNum     uint            // 磁盘上数据文件的数量
+	ReclaimableSize int64           // 可以通过 merge 回收的数据量，以字节为单位
+	DiskSize        int64           // 数据目录所占磁盘空间的大小
+	AutoMerge       MergeStatus     // 后台自动 merge 的运行状态
+	Recovery        *RecoveryReport // 启动时活跃文件尾部损坏数据的处理情况，没有损坏时为空
 }
 
 // Stat 返回数据库的相关统计信息
-func (db *DB) Stat() *Stat {
+func (db *DB) Stat() (*Stat, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
 	var dataFiles = uint(len(db.olderFiles))
 	if db.activeFile != nil {
 		dataFiles++
@@ -58,40 +98,56 @@ func (db *DB) Stat() *Stat {
 
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size : %v", err))
+		return nil, fmt.Errorf("failed to get dir size: %w", err)
 	}
 	return &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
 		DiskSize:        dirSize,
-	}
+		AutoMerge:       db.mergeStatus,
+		Recovery:        db.recoveryReport,
+	}, nil
 }
 
 // Open 打开存储引擎实例
-func Open(options Options) (*DB, error) {
+func Open(options Options) (db *DB, err error) {
+	start := time.Now()
 	// 校验用户传入的配置项
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
 
 	var isInitial bool
-	// 判断数据目录是否存在，不存在需要创建这个目录
+	// 判断数据目录是否存在，不存在需要创建这个目录，只读模式下直接返回错误
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+	// 判断当前数据目录是否正在被其他写实例使用
+	// 只读实例不加锁，和写实例、其他只读实例以及 fsck 之间都不做任何协调，写实例 merge 删除的文件需要通过 Refresh 感知
+	var fileLock *flock.Flock
+	if !options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		var hold bool
+		if hold, err = fileLock.TryLock(); err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+		// 打开失败时释放文件锁，之后可以重新打开
+		defer func() {
+			if err != nil {
+				_ = fileLock.Unlock()
+			}
+		}()
 	}
 
 	entries, err := os.ReadDir(options.DirPath)
@@ -103,14 +159,76 @@ func Open(options Options) (*DB, error) {
 		isInitial = true
 	}
 
+	// 检查数据目录的格式版本，不兼容时不能修改目录中的任何文件
+	manifest, err := readManifest(options.DirPath)
+	if err == nil && manifest != nil {
+		err = checkManifest(manifest, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 按照配置的校验算法编码写入的数据，配置了密钥时加密
+	codec, err := newCodec(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// 初始化内存索引，B+ 树索引需要打开索引文件
+	indexer, err := index.NewIndexer(options.IndexerType, options.DirPath, options.SyncWrites)
+	if err != nil {
+		return nil, err
+	}
+	// 内存索引需要统计 key 的总长度，用于估算占用的内存
+	if options.IndexerType != BPlusTreeIndex {
+		indexer = &meteredIndex{Indexer: indexer}
+	}
+
 	// 初始化 DB 实例结构体
-	db := &DB{
-		mu:         new(sync.RWMutex),
-		options:    options,
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexerType, options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+	db = &DB{
+		mu:             new(sync.RWMutex),
+		options:        options,
+		olderFiles:     make(map[uint32]*data.DataFile),
+		blobFiles:      make(map[uint32]*data.DataFile),
+		streamingBlobs: make(map[uint32]int),
+		groupCommit:    newGroupCommit(options.GroupCommitMaxDelay, options.GroupCommitMaxBatch),
+		fileDeadBytes:  make(map[uint32]int64),
+		obsoleteFiles:  make(map[*data.DataFile]struct{}),
+		txnWrites:      make(map[string]uint64),
+		unsyncedIndex:  make(map[string]*unsyncedIndexOp),
+		index:          indexer,
+		isInitial:      isInitial,
+		fileLock:       fileLock,
+		manifest:       manifest,
+		codec:          codec,
+		logger:         options.Logger,
+		listener:       options.EventListener,
+		metrics:        newMetrics(),
+	}
+	if db.logger == nil {
+		db.logger = nopLogger{}
+	}
+	if db.listener == nil {
+		db.listener = NoopEventListener{}
+	}
+	// 打开失败时关闭已经打开的文件和索引，B+ 树索引文件不关闭时无法再次打开
+	opened := db
+	defer func() {
+		if err != nil {
+			opened.mu.Lock()
+			_ = opened.closeFiles()
+			opened.mu.Unlock()
+			_ = indexer.Close()
+		}
+	}()
+
+	// 只读实例不处理 merge 目录，也不修改数据文件，写实例可能正在修改数据文件，加载失败时会重试
+	if options.ReadOnly {
+		if err := db.reload(); err != nil {
+			return nil, err
+		}
+		db.logOpened(start)
+		return db, nil
 	}
 
 	// 加载 merge 数据目录
@@ -125,13 +243,11 @@ func Open(options Options) (*DB, error) {
 
 	// B+ 树索引不需要从数据文件中加载索引
 	if options.IndexerType != BPlusTreeIndex {
-		// 从 hint 索引文件中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
+		if err := db.loadIndex(); err != nil {
 			return nil, err
 		}
-		// 从数据文件中加载 LogRecord 并更新索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+		if db.recoveryReport != nil {
+			db.onRecoveryTruncated(*db.recoveryReport)
 		}
 
 		// 重置 IO 类型为标准文件 IO
@@ -156,28 +272,102 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 开启后台自动 merge
+	db.startAutoMerge()
+
+	db.logOpened(start)
 	return db, nil
 }
 
-// Close 关闭数据库
+// logOpened 输出打开数据库的日志
+func (db *DB) logOpened(start time.Time) {
+	dataFiles := len(db.olderFiles)
+	if db.activeFile != nil {
+		dataFiles++
+	}
+	db.logger.Info("database opened", "dir", db.options.DirPath, "readOnly", db.options.ReadOnly,
+		"dataFileNum", dataFiles, "keyNum", db.index.Size(), "duration", time.Since(start))
+}
+
+// Close 关闭数据库，出错时依然会尽量释放所有资源，返回遇到的所有错误
+// 关闭之后的操作都返回 ErrDatabaseClosed，仍被快照、迭代器或读操作引用的文件在引用释放后关闭
 func (db *DB) Close() error {
-	defer func() {
-		// 释放文件锁
-		if err := db.fileLock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
-		}
-		// 关闭索引
-		if err := db.index.Close(); err != nil {
-			panic(fmt.Sprintf("failed to close the index, %v", err))
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	// 先停止后台自动 merge，等待正在进行的 merge 完成
+	db.stopAutoMerge()
+
+	var errs []error
+	if db.options.IndexCheckpointOnClose && !db.options.ReadOnly {
+		if err := db.CheckpointIndex(); err != nil {
+			errs = append(errs, err)
 		}
-	}()
-	if db.activeFile == nil {
-		return nil
 	}
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	db.closed.Store(true)
+
+	// 保存当前事务序列号，并持久化还没有写入磁盘的数据，只读实例不修改数据目录
+	if db.activeFile != nil && !db.options.ReadOnly {
+		if err := db.saveSeqNo(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := db.syncActiveFiles(); err != nil {
+			// 持久化失败，等待持久化的写入不再更新索引
+			db.settleUnsyncedWrites(db.writeSeq, err)
+			errs = append(errs, err)
+		}
+	}
+
+	// 关闭数据文件和 blob 文件
+	if err := db.closeFiles(); err != nil {
+		errs = append(errs, err)
+	}
+	// 关闭索引
+	if err := db.index.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close the index: %w", err))
+	}
+	// 释放文件锁
+	if db.fileLock != nil {
+		if err := db.fileLock.Unlock(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unlock the directory: %w", err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		db.logger.Error("failed to close database", "dir", db.options.DirPath, "err", err)
+	} else {
+		db.logger.Info("database closed", "dir", db.options.DirPath)
+	}
+	return err
+}
 
-	// 保存当前事务序列号
+// closeFiles 关闭所有的数据文件和 blob 文件，仍被引用的文件在引用释放后关闭，需要持有互斥锁
+func (db *DB) closeFiles() error {
+	var errs []error
+	if db.activeFile != nil {
+		if err := db.retireDataFile(db.activeFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// 活跃 blob 文件也在 blobFiles 中
+	for _, fileMap := range []map[uint32]*data.DataFile{db.olderFiles, db.blobFiles} {
+		for _, file := range fileMap {
+			if err := db.retireDataFile(file); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// saveSeqNo 将当前事务序列号保存到文件中，B+ 树索引在启动时从中读取
+func (db *DB) saveSeqNo() error {
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	if err != nil {
 		return err
@@ -186,47 +376,44 @@ func (db *DB) Close() error {
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNoFile.Sync(); err != nil {
+	encRecord, _, err := db.codec.EncodeLogRecord(record)
+	if err != nil {
 		return err
 	}
-
-	// 关闭当前活跃文件
-	if err := db.activeFile.Close(); err != nil {
+	if err := seqNoFile.Write(encRecord); err != nil {
 		return err
 	}
-
-	// 关闭当前旧文件
-	for _, file := range db.olderFiles {
-		if err := file.Close(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return seqNoFile.Sync()
 }
 
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFiles()
 }
 
-// ListKeys 获取数据库中的所有 key
+// ListKeys 获取数据库中的所有 key，数据库关闭之后返回空
 func (db *DB) ListKeys() [][]byte {
+	if db.closed.Load() {
+		return nil
+	}
 	iterator := db.index.Iterator(false)
 	defer iterator.Close() // 关闭，防止读写互斥阻塞
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		// 跳过已经过期的 key
+		if isExpired(iterator.Value(), now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -235,10 +422,17 @@ func (db *DB) ListKeys() [][]byte {
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 
 	iterator := db.index.Iterator(false)
 	defer iterator.Close() // 关闭，防止读写互斥阻塞
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if isExpired(iterator.Value(), now) {
+			continue
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -260,7 +454,17 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
+	return readValue(dataFile, db.blobFiles, logRecordPos)
+}
+
+// getPinnedValue 从快照或迭代器引用的文件中获取 value
+// 引用的文件可能已经被 merge 替换或被 compaction 删除，因此不能按照文件 id 在当前的数据文件中查找
+func getPinnedValue(files *fileSet, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	return readValue(files.dataFiles[logRecordPos.Fid], files.blobFiles, logRecordPos)
+}
 
+// readValue 根据索引位置信息从数据文件中读取 value，存储在 blob 文件中的 value 从 blobFiles 中读取
+func readValue(dataFile *data.DataFile, blobFiles map[uint32]*data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// 根据文件 id，未找到该文件
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -277,13 +481,19 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 		return nil, ErrKeyNotFound
 	}
 
-	return logRecord.Value, nil
+	if logRecord.Compression == data.CompressionBlobRef {
+		return readBlobValue(blobFiles, logRecord.Value)
+	}
+	return data.DecompressValue(logRecord.Compression, logRecord.Value)
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// dataFileExist 判断文件 id 对应的数据文件是否存在
+func (db *DB) dataFileExist(fid uint32) bool {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return true
+	}
+	_, ok := db.olderFiles[fid]
+	return ok
 }
 
 // 将 LogRecord 追加写入活跃文件中
@@ -291,14 +501,29 @@ func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecor
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 	// 判断当前活跃文件是否存在，数据库刚初始化的时候没有任何数据文件存在，因此要新增一个文件
 	if db.activeFile == nil {
-		if err := db.setActiveDataFile(); err != nil {
+		if err := db.setActiveDataFile(0); err != nil {
 			return nil, err
 		}
 	}
 
+	// 根据配置将较大的 value 写入 blob 文件
+	logRecord, err := db.writeBlobValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据配置压缩 value
+	logRecord, err = db.compressLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
 	// 已经拥有活跃文件了，将传入的 logRecord 追加写入
 	// 写入前还需要编码成字节数组
-	encodedLogRecord, size := data.EncodeLogRecord(logRecord)
+	encodedLogRecord, size, err := db.codec.EncodeLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
 
 	// 根据 bitcask 论文描述，如果当前活跃文件写会到达阈值，就要关闭当前活跃文件，重新打开一个
 
@@ -306,16 +531,8 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
 		// TODO: 【Optimization】如果写入数据的大小超过了多个文件的阈值，就需要打开多个新文件
 
-		// 先持久化数据文件，保证数据已持久化到磁盘上
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-
-		// 将当前活跃文件转换成旧数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开并设置新的活跃数据文件
-		if err := db.setActiveDataFile(); err != nil {
+		// 将当前活跃文件转换成旧数据文件，并打开新的活跃文件
+		if err := db.rotateActiveFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -327,13 +544,12 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	db.bytesWrite += uint(size)
-	// 根据用户配置项决定写入后是否进行持久化
-	var needSync = db.options.SyncWrites
-	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
-		needSync = true
-	}
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+	db.metrics.bytesWritten.Add(uint64(size))
+	db.writeSeq++
+	// 累计写入的数据达到 BytesPerSync 时进行持久化
+	// SyncWrites 要求的持久化由调用方在释放互斥锁之后通过 group commit 完成，见 update
+	if db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
+		if err := db.syncActiveFiles(); err != nil {
 			return nil, err
 		}
 		// 清空累计值
@@ -347,32 +563,186 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
+	if err := db.appendHintRecord(logRecord.Key, logRecord.Type, pos); err != nil {
+		return nil, err
 	}
 	return pos, nil
 }
 
-// 设置当前活跃文件 需要持有互斥锁
-func (db *DB) setActiveDataFile() error {
-	var initialFileId uint32 = 0
+// rotateActiveFile 在活跃文件末尾写入结束标识，将其转换成旧数据文件，并打开新的活跃文件，需要持有互斥锁
+func (db *DB) rotateActiveFile() error {
+	return db.rotateActiveFileTo(db.activeFile.FileId + 1)
+}
 
-	// 已经有活跃文件了，则新的活跃文件 id 是当前活跃文件 id + 1（递增）
-	if db.activeFile != nil {
-		initialFileId = db.activeFile.FileId + 1
+// rotateActiveFileTo 和 rotateActiveFile 相同，新的活跃文件 id 为 fileId，需要大于所有已有的数据文件 id
+func (db *DB) rotateActiveFileTo(fileId uint32) error {
+	if err := db.activeFile.Write(db.codec.EncodeEndOfFile()); err != nil {
+		return err
 	}
+	// 先持久化数据文件，保证数据已持久化到磁盘上
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	info := FileRotatedInfo{OldFileId: db.activeFile.FileId, OldFileSize: db.activeFile.WriteOff}
+	if err := db.retireActiveFile(fileId); err != nil {
+		return err
+	}
+	info.NewFileId = db.activeFile.FileId
+	db.onFileRotated(info)
+	return nil
+}
 
+// retireActiveFile 将已经持久化的活跃文件转换成旧数据文件，并打开 id 为 nextFileId 的新活跃文件，需要持有互斥锁
+func (db *DB) retireActiveFile(nextFileId uint32) error {
+	// 写入活跃文件对应的 hint 文件，用于加快启动时加载索引的速度
+	if err := db.writeActiveHintFile(); err != nil {
+		return err
+	}
+
+	// 将当前活跃文件转换成旧数据文件
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+
+	// 打开并设置新的活跃数据文件
+	return db.setActiveDataFile(nextFileId)
+}
+
+// compressLogRecord 按照配置压缩 LogRecord 的 value，返回新的 LogRecord，不修改传入的数据
+// 已经压缩过的数据（例如 merge 时重写的数据）、长度小于阈值或者压缩后没有变小的数据都直接存储原始数据
+func (db *DB) compressLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.Compression == NoCompression ||
+		logRecord.Type != data.LogRecordNormal ||
+		logRecord.Compression != data.CompressionNone ||
+		len(logRecord.Value) < db.options.CompressionThreshold {
+		return logRecord, nil
+	}
+	compressed, err := data.CompressValue(db.options.Compression, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+	return &data.LogRecord{
+		Key:         logRecord.Key,
+		Value:       compressed,
+		Type:        logRecord.Type,
+		Expire:      logRecord.Expire,
+		Compression: db.options.Compression,
+	}, nil
+}
+
+// 设置当前活跃文件 需要持有互斥锁
+// 文件 id 通常是当前活跃文件 id + 1（递增），merge 时可能跳过一段 id 留给 merge 生成的文件
+func (db *DB) setActiveDataFile(fileId uint32) error {
 	// 根据配置项中传递过来的目录，在该目录下打开新的数据文件，并将其设置会新的活跃文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	dataFile.Codec = db.codec
 	db.activeFile = dataFile
-	return nil
+	db.publishFiles()
+	// 新的文件写入数据之前先记录到 MANIFEST 中
+	return db.saveManifest()
+}
+
+// fileSet 数据文件和 blob 文件的集合，创建之后不再修改
+// 当前的文件集合在文件发生变化时重新发布，不持有互斥锁的读操作从中查找文件，快照和迭代器直接引用发布的集合
+type fileSet struct {
+	dataFiles map[uint32]*data.DataFile // 所有的数据文件，包括活跃文件
+	blobFiles map[uint32]*data.DataFile // 所有的 blob 文件，包括活跃 blob 文件
+}
+
+// publishFiles 发布当前的数据文件和 blob 文件集合，增加或删除文件之后调用，需要持有互斥锁
+func (db *DB) publishFiles() {
+	files := &fileSet{
+		dataFiles: make(map[uint32]*data.DataFile, len(db.olderFiles)+1),
+		blobFiles: make(map[uint32]*data.DataFile, len(db.blobFiles)),
+	}
+	for fid, file := range db.olderFiles {
+		files.dataFiles[fid] = file
+	}
+	if db.activeFile != nil {
+		files.dataFiles[db.activeFile.FileId] = db.activeFile
+	}
+	for fid, file := range db.blobFiles {
+		files.blobFiles[fid] = file
+	}
+	db.files.Store(files)
+}
+
+// pinDataFiles 引用当前所有的数据文件和 blob 文件，返回被引用的文件，需要持有互斥锁
+func (db *DB) pinDataFiles() *fileSet {
+	files := db.files.Load()
+	// 持有互斥锁时发布的集合和当前的文件一致，其中的文件都还没有被删除
+	for _, file := range files.dataFiles {
+		file.Acquire()
+	}
+	for _, file := range files.blobFiles {
+		file.Acquire()
+	}
+	return files
+}
+
+// unpinDataFiles 释放对数据文件和 blob 文件的引用
+func (db *DB) unpinDataFiles(files *fileSet) {
+	for _, fileMap := range []map[uint32]*data.DataFile{files.dataFiles, files.blobFiles} {
+		for _, file := range fileMap {
+			db.releaseFile(file)
+		}
+	}
+}
+
+// releaseFile 释放对文件的引用，已经被删除的文件不再被引用时关闭，不能持有互斥锁
+func (db *DB) releaseFile(file *data.DataFile) {
+	if !file.Release() {
+		return
+	}
+	db.mu.Lock()
+	delete(db.obsoleteFiles, file)
+	db.mu.Unlock()
+	_ = file.Close()
+}
+
+// retireDataFile 关闭已经从数据目录中删除的文件，需要持有互斥锁
+// 仍被快照、迭代器或读操作引用的文件先不关闭，已经打开的文件在删除后依然可以读取
+func (db *DB) retireDataFile(dataFile *data.DataFile) error {
+	if !dataFile.Retire() {
+		db.obsoleteFiles[dataFile] = struct{}{}
+		return nil
+	}
+	return dataFile.Close()
+}
+
+// markReclaimable 将 pos 对应的数据标记为无效，同时更新所在文件的无效数据量，需要持有互斥锁
+func (db *DB) markReclaimable(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.fileDeadBytes[pos.Fid] += int64(pos.Size)
+}
+
+// trackTxnWrite 有事务正在进行时，记录 key 的修改，用于事务提交时的冲突检测，需要持有互斥锁
+// 记录的数量达到 maxTxnWrites 时清空记录并提高 txnWritesFloor，避免没有结束的事务导致记录无限增长
+func (db *DB) trackTxnWrite(key []byte, seqNo uint64) {
+	if db.activeTxns == 0 {
+		return
+	}
+	if len(db.txnWrites) >= maxTxnWrites {
+		db.txnWritesFloor = seqNo
+		db.txnWrites = make(map[string]uint64)
+		return
+	}
+	db.txnWrites[string(key)] = seqNo
 }
 
 // Backup 备份数据库，将数据文件拷贝到新的目录中
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
 	// 第三个参数填写要排除的文件 pattern
 	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
 }
@@ -380,62 +750,222 @@ func (db *DB) Backup(dir string) error {
 // Put 数据库写操作，往数据库中写入 K-V 数据，保证 key 非空
 // 先写磁盘文件，在更新内存索引
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// PutWithTTL 写入 K-V 数据，并设置过期时间，过期后该 key 对读取操作不可见，并在 merge 时被清理
+// ttl 小于等于 0 时等同于 Put
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return db.put(key, value, expire)
+}
+
+func (db *DB) put(key []byte, value []byte, expire int64) error {
+	defer db.metrics.putLatency.observeSince(time.Now())
 	// 判断 key 是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	// 写数据文件和更新内存索引在同一个临界区中完成，保证索引和日志的顺序一致
+	return db.update(db.options.SyncWrites, func() error {
+		return db.putLocked(key, value, expire)
+	})
+}
+
+// putLocked 写入数据并更新内存索引，需要持有互斥锁
+func (db *DB) putLocked(key []byte, value []byte, expire int64) error {
 	// 构造 LogReCord 结构体
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
 	// 将构造出来的日志记录，追加写入数据文件，并得到索引位置
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
+	db.trackTxnWrite(key, db.seqNo)
+
 	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
+	db.putIndex(key, pos)
 	return nil
 }
 
-// Get 数据库读操作，根据 key，读取 Value。需要获取读锁
+// Get 数据库读操作，根据 key，读取 Value。通常不需要获取锁
 // 先根据 key，从内存中获取索引信息，得到数据存放的文件 id 以及偏移量，并根据 id 和 偏移量获取数据
 // 返回字节数组
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
+	defer db.metrics.getLatency.observeSince(time.Now())
 	// 判断 key 是否非空
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	if value, ok, err := db.getWithoutLock(key); ok {
+		return value, err
+	}
+	// 读取期间文件被删除或替换了，获取读锁重新读取
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getLocked(key)
+}
+
+// getWithoutLock 不持有互斥锁读取 key 对应的 value，读取期间引用用到的文件，避免文件被关闭
+// 写操作在更新索引之前已经写完了数据，并发布了新建的文件，因此索引指向的位置总是可以读取
+// 用到的文件已经被 merge 或 compaction 删除，或者读取期间 merge 替换了数据文件时返回 false，由调用方持有锁重新读取
+func (db *DB) getWithoutLock(key []byte) ([]byte, bool, error) {
+	swaps := db.fileSwaps.Load()
+	if swaps%2 == 1 {
+		return nil, false, nil
+	}
 
 	// 从内存数据结构中取出 key 对应的索引信息
 	logRecordPos := db.index.Get(key)
-	// 该 key 不存在
-	if logRecordPos == nil {
-		return nil, ErrKeyNotFound
+	// 该 key 不存在或已经过期
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, true, ErrKeyNotFound
 	}
-	//  从数据文件中获取 value
-	return db.getValueByPosition(logRecordPos)
+
+	files := db.files.Load()
+	dataFile := files.dataFiles[logRecordPos.Fid]
+	if dataFile == nil || !dataFile.Acquire() {
+		return nil, false, nil
+	}
+	defer db.releaseFile(dataFile)
+
+	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	// merge 替换了数据文件时，索引中的位置可能指向了同一个 id 的新文件，读到的数据不可信
+	if db.fileSwaps.Load() != swaps {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, true, ErrKeyNotFound
+	}
+	if logRecord.Compression != data.CompressionBlobRef {
+		value, err := data.DecompressValue(logRecord.Compression, logRecord.Value)
+		return value, true, err
+	}
+
+	// blob 文件的 id 不会被重新使用，只需要引用分块所在的 blob 文件，同一个文件只引用一次
+	ref, err := data.DecodeBlobRef(logRecord.Value)
+	if err != nil {
+		return nil, true, err
+	}
+	blobFiles := make(map[uint32]*data.DataFile, 1)
+	defer func() {
+		for _, blobFile := range blobFiles {
+			db.releaseFile(blobFile)
+		}
+	}()
+	for _, blobPos := range ref.Chunks {
+		if _, ok := blobFiles[blobPos.Fid]; ok {
+			continue
+		}
+		blobFile := files.blobFiles[blobPos.Fid]
+		if blobFile == nil || !blobFile.Acquire() {
+			return nil, false, nil
+		}
+		blobFiles[blobPos.Fid] = blobFile
+	}
+	value, err := readBlobValue(blobFiles, logRecord.Value)
+	return value, true, err
+}
+
+// TTL 获取 key 剩余的存活时间，key 没有设置过期时间时返回 0
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	if db.closed.Load() {
+		return 0, ErrDatabaseClosed
+	}
+
+	logRecordPos := db.index.Get(key)
+	now := time.Now().UnixNano()
+	if logRecordPos == nil || isExpired(logRecordPos, now) {
+		return 0, ErrKeyNotFound
+	}
+	if logRecordPos.Expire == 0 {
+		return 0, nil
+	}
+	return time.Duration(logRecordPos.Expire - now), nil
+}
+
+// Persist 移除 key 的过期时间，使其永久有效
+// 会重新写入一条不带过期时间的记录
+func (db *DB) Persist(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	// 读取旧值和写入新记录需要在同一个临界区中完成
+	return db.update(db.options.SyncWrites, func() error {
+		return db.persistLocked(key)
+	})
+}
+
+// persistLocked 去掉 key 的过期时间，需要持有互斥锁
+func (db *DB) persistLocked(key []byte) error {
+	logRecordPos := db.latestPos(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return ErrKeyNotFound
+	}
+	// 本身就没有过期时间
+	if logRecordPos.Expire == 0 {
+		return nil
+	}
+
+	value, err := db.getValueByPosition(logRecordPos)
+	if err != nil {
+		return err
+	}
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: value,
+		Type:  data.LogRecordNormal,
+	})
+	if err != nil {
+		return err
+	}
+	db.trackTxnWrite(key, db.seqNo)
+	db.putIndex(key, pos)
+	return nil
 }
 
 // Delete 数据库删除操作，根据 key 删除数据
 func (db *DB) Delete(key []byte) error {
+	defer db.metrics.deleteLatency.observeSince(time.Now())
 	// 判断 key 有效性
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	return db.update(db.options.SyncWrites, func() error {
+		return db.deleteLocked(key)
+	})
+}
+
+// deleteLocked 写入删除标记并删除内存索引，需要持有互斥锁
+func (db *DB) deleteLocked(key []byte) error {
 	// 先检查 key 是否存在，若不存在直接返回
-	if pos := db.index.Get(key); pos == nil {
+	if pos := db.latestPos(key); pos == nil {
 		return nil
 	}
 
@@ -444,22 +974,122 @@ func (db *DB) Delete(key []byte) error {
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
 		Type: data.LogRecordDeleted,
 	}
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size)
+	db.trackTxnWrite(key, db.seqNo)
+	db.markReclaimable(pos)
 	// 并在内存索引中删除对应的 key
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
+	if !db.deleteIndex(key) {
 		return ErrIndexUpdateFailed
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
 	return nil
 }
 
+// getLocked 读取 key 对应的 value，过期的 key 视为不存在，需要持有锁
+func (db *DB) getLocked(key []byte) ([]byte, error) {
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(logRecordPos)
+}
+
+// getLatestLocked 读取 key 最近一次写入的 value，包括还在等待持久化的写入，需要持有互斥锁
+// 条件写入根据它判断 key 的当前值，保证和之前的写入按照顺序生效
+func (db *DB) getLatestLocked(key []byte) ([]byte, error) {
+	logRecordPos := db.latestPos(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(logRecordPos)
+}
+
+// CompareAndSwap 当 key 当前的值等于 oldValue 时，将其更新为 newValue
+// 返回是否更新成功，key 不存在时返回 false
+func (db *DB) CompareAndSwap(key, oldValue, newValue []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	// 读取、比较和写入在同一个临界区中完成
+	var swapped bool
+	err := db.update(db.options.SyncWrites, func() error {
+		value, err := db.getLatestLocked(key)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(value, oldValue) {
+			return nil
+		}
+		if err := db.putLocked(key, newValue, 0); err != nil {
+			return err
+		}
+		swapped = true
+		return nil
+	})
+	return swapped && err == nil, err
+}
+
+// PutIfAbsent 只有当 key 不存在（或已过期）时才写入，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	var written bool
+	err := db.update(db.options.SyncWrites, func() error {
+		if _, err := db.getLatestLocked(key); err != ErrKeyNotFound {
+			return err
+		}
+		if err := db.putLocked(key, value, 0); err != nil {
+			return err
+		}
+		written = true
+		return nil
+	})
+	return written && err == nil, err
+}
+
+// DeleteIfEqual 只有当 key 当前的值等于 value 时才删除，返回是否删除成功
+func (db *DB) DeleteIfEqual(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	var deleted bool
+	err := db.update(db.options.SyncWrites, func() error {
+		current, err := db.getLatestLocked(key)
+		if err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, value) {
+			return nil
+		}
+		if err := db.deleteLocked(key); err != nil {
+			return err
+		}
+		deleted = true
+		return nil
+	})
+	return deleted && err == nil, err
+}
+
+// isExpired 判断索引位置对应的数据在 now 时刻是否已经过期
+func isExpired(pos *data.LogRecordPos, now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
+}
+
 // checkOptions 校验配置项
 func checkOptions(options Options) error {
 	if options.DirPath == "" {
@@ -471,15 +1101,110 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
+	if options.DataFileCompactRatio < 0 || options.DataFileCompactRatio > 1 {
+		return errors.New("invalid compact ratio, must between 0 and 1")
+	}
+	if options.RecoveryMode < RecoveryTruncate || options.RecoveryMode > RecoverySkip {
+		return errors.New("invalid recovery mode")
+	}
+	if options.Compression > DeflateCompression {
+		return errors.New("invalid compression type")
+	}
+	if options.CompressionThreshold < 0 {
+		return errors.New("compression threshold must not be negative")
+	}
+	if options.BlobThreshold < 0 {
+		return errors.New("blob threshold must not be negative")
+	}
+	if options.BlobGCRatio < 0 || options.BlobGCRatio > 1 {
+		return errors.New("invalid blob gc ratio, must between 0 and 1")
+	}
+	if options.Checksum > ChecksumXXHash64 {
+		return errors.New("invalid checksum type")
+	}
+	if len(options.EncryptionKey) > 0 && options.KeyProvider != nil {
+		return errors.New("encryption key and key provider can not be set at the same time")
+	}
+	// B+ 树索引将 key 明文存储在磁盘上
+	if (len(options.EncryptionKey) > 0 || options.KeyProvider != nil) && options.IndexerType == BPlusTreeIndex {
+		return errors.New("encryption is not supported by the B+ tree index")
+	}
+	// B+ 树索引文件只能被一个进程打开
+	if options.ReadOnly && options.IndexerType == BPlusTreeIndex {
+		return errors.New("read-only mode is not supported by the B+ tree index")
+	}
+	if options.GroupCommitMaxDelay < 0 || options.GroupCommitMaxBatch < 0 {
+		return errors.New("group commit max delay and max batch must not be negative")
+	}
+	if options.LoadConcurrency < 0 {
+		return errors.New("load concurrency must not be negative")
+	}
+	if options.CompactMaxFiles < 0 {
+		return errors.New("compact max files must not be negative")
+	}
+	if options.AutoMergeInterval < 0 {
+		return errors.New("auto merge interval must not be negative")
+	}
+	if options.AutoMergeWindowStart < 0 || options.AutoMergeWindowStart >= 24*time.Hour ||
+		options.AutoMergeWindowEnd < 0 || options.AutoMergeWindowEnd >= 24*time.Hour {
+		return errors.New("invalid auto merge window, must between 0 and 24h")
+	}
 	return nil
 }
 
 // loadDataFiles 加载数据文件
 func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := db.listDataFileIds()
 	if err != nil {
+		return err
+	}
+
+	// 遍历文件 id，依次打开
+	for i, fid := range fileIds {
+		ioType := fio.StandardFIO
+		if db.options.ReadOnly {
+			ioType = fio.ReadOnlyFIO
+		} else if db.options.MMapAtStartup {
+			ioType = fio.MemoryMap
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		if err != nil {
+			return err
+		}
+		dataFile.Codec = db.codec
+		// 将最后一个文件变为活跃文件
+		if i == len(fileIds)-1 {
+			db.activeFile = dataFile
+		} else { // 说明是旧文件
+			db.olderFiles[uint32(fid)] = dataFile
+		}
+	}
+	db.fileIds = fileIds
+
+	// blob 文件需要在更新 MANIFEST 之前加载
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+	db.publishFiles()
+
+	if db.options.ReadOnly {
 		return nil
 	}
+	// 旧目录升级，或者配置项发生了变化，都需要更新 MANIFEST
+	return db.saveManifest()
+}
+
+// listDataFileIds 获取需要加载的数据文件 id，从小到大排序
+// 有 MANIFEST 时只加载其中记录的文件，目录中没有被记录的数据文件是新建之后还没有写入 MANIFEST，或者从 MANIFEST 中去掉之后还没有删除就发生了崩溃
+// id 大于 MANIFEST 中所有文件的是新建的活跃文件，MANIFEST 的更新可能因为崩溃丢失，文件中可能已经有持久化的数据，需要保留并加载
+// 其余没有被记录的文件都不包含有效数据，直接删除
+// 只读模式下不删除这些文件，它们可能是写实例刚刚新建的文件
+// 没有 MANIFEST 的旧目录根据目录中的文件名确定
+func (db *DB) listDataFileIds() ([]int, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, nil
+	}
 
 	var fileIds []int
 	// 遍历目录下的文件，找到所有以【.data】结尾的文件
@@ -490,39 +1215,71 @@ func (db *DB) loadDataFiles() error {
 			fileId, err := strconv.Atoi(splitedName[0])
 			// 数据目录可能已损坏
 			if err != nil {
-				return ErrDataDirectoryCorrupted
+				return nil, ErrDataDirectoryCorrupted
 			}
 			fileIds = append(fileIds, fileId)
 		}
 	}
-
 	// 对文件 id 进行排序，从小到大依次加载
 	sort.Ints(fileIds)
+	if db.manifest == nil {
+		return fileIds, nil
+	}
 
-	// 遍历文件 id，依次打开
-	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
+	listed := make(map[uint32]struct{}, len(db.manifest.fileIds))
+	for _, fid := range db.manifest.fileIds {
+		listed[fid] = struct{}{}
+	}
+	existing := make(map[uint32]struct{}, len(fileIds))
+	var recovered []int
+	for _, fid := range fileIds {
+		existing[uint32(fid)] = struct{}{}
+		if _, ok := listed[uint32(fid)]; ok || db.options.ReadOnly {
+			continue
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
-		if err != nil {
-			return err
+		if n := len(db.manifest.fileIds); n == 0 || uint32(fid) > db.manifest.fileIds[n-1] {
+			recovered = append(recovered, fid)
+			continue
 		}
-		// 将最后一个文件变为活跃文件
-		if i == len(fileIds)-1 {
-			db.activeFile = dataFile
-		} else { // 说明是旧文件
-			db.olderFiles[uint32(fid)] = dataFile
+		if err := os.Remove(data.GetDataFileName(db.options.DirPath, uint32(fid))); err != nil {
+			return nil, err
+		}
+		if err := removeHintFile(db.options.DirPath, uint32(fid)); err != nil {
+			return nil, err
 		}
 	}
-	db.fileIds = fileIds
-	return nil
+
+	fileIds = fileIds[:0]
+	for _, fid := range db.manifest.fileIds {
+		if _, ok := existing[fid]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrDataFileMissing, filepath.Base(data.GetDataFileName(db.options.DirPath, fid)))
+		}
+		fileIds = append(fileIds, int(fid))
+	}
+	// 保留的文件 id 都大于 MANIFEST 中的文件，追加之后仍然有序，加载完成后会写入 MANIFEST
+	return append(fileIds, recovered...), nil
+}
+
+// loadIndex 从 index checkpoint、hint 文件和数据文件中加载内存索引
+func (db *DB) loadIndex() error {
+	// 优先从 index checkpoint 中加载索引，之后只需要加载 checkpoint 之后写入的数据
+	cpFileId, cpOffset, ok, err := db.loadIndexCheckpoint()
+	if err != nil {
+		return err
+	}
+	// 从 hint 索引文件中加载索引
+	if !ok {
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return err
+		}
+	}
+	// 从数据文件中加载 LogRecord 并更新索引
+	return db.loadIndexFromDataFiles(cpFileId, cpOffset)
 }
 
 // loadIndexFromDataFiles 从数据文件中加载索引
-// 遍历文件中的所有记录，并更新到内存索引中
-func (db *DB) loadIndexFromDataFiles() error {
+// 遍历文件中从 startFileId 的 startOffset 位置开始的所有记录，并更新到内存索引中
+func (db *DB) loadIndexFromDataFiles(startFileId uint32, startOffset int64) error {
 	// 没有文件直接返回
 	if len(db.fileIds) == 0 {
 		return nil
@@ -541,97 +1298,105 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
+	now := time.Now().UnixNano()
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
 		var oldPos *data.LogRecordPos
-		// 对已删除的记录进行处理
-		if typ == data.LogRecordDeleted {
+		// 对已删除或已过期的记录进行处理
+		if typ == data.LogRecordDeleted || isExpired(pos, now) {
 			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+			db.markReclaimable(pos)
 		} else {
 			oldPos = db.index.Put(key, pos)
 		}
 		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+			db.markReclaimable(oldPos)
 		}
 	}
 
-	// 暂存事务操作中的数据
-	txnRecords := make(map[uint64][]*data.TransactionRecord)
-	var curSeqNo = nonTransactionSeqNo
+	// 暂存事务操作中的数据，只读模式下还没有读到完成标识的事务保留到下一次 Refresh 继续处理
+	txnRecords := db.pendingTxns
+	if txnRecords == nil {
+		txnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+	var curSeqNo = db.seqNo
 
-	// 遍历所有文件 id，处理文件中的记录
-	for i, fd := range db.fileIds {
-		// 获取文件
-		var fileId = uint32(fd)
+	// 处理一条记录，hint 文件中保留了原记录的 key 和类型，和数据文件中的记录处理方式相同
+	processRecord := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		// 解析 Key，拿到事务序列号
+		realKey, seqNo := parseLogRcordKeyWithSeqNo(key)
+		if seqNo == nonTransactionSeqNo {
+			// 非事务操作，直接更新内存索引
+			updateIndex(realKey, typ, pos)
+		} else {
+			// 事务完成，对应事务中的所有数据可以更新到内存索引中
+			if typ == data.LogRecordTxnFinished {
+				for _, txnRecord := range txnRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(txnRecords, seqNo)
+			} else {
+				//  事务中的数据还未全部获取，先暂存起来
+				txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
+					Record: &data.LogRecord{Key: realKey, Type: typ},
+					Pos:    pos,
+				})
+			}
+		}
 
-		// 排除 merge 过的数据，其已经通过 hint 索引文件加载
-		if hasMerge && fileId < nonMergeFileId {
-			continue
+		// 更新事务序列号
+		if seqNo > curSeqNo {
+			curSeqNo = seqNo
 		}
+	}
 
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
-		} else {
-			dataFile = db.olderFiles[fileId]
+	// 排除 merge 过的数据，其已经通过 hint 索引文件加载
+	// 以及 checkpoint 已经覆盖到的数据
+	var loadFileIds []uint32
+	for _, fd := range db.fileIds {
+		if hasMerge && uint32(fd) < nonMergeFileId || uint32(fd) < startFileId {
+			continue
 		}
+		loadFileIds = append(loadFileIds, uint32(fd))
+	}
 
-		// 循环处理文件中的所有记录
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
+	// 并行解析所有文件，再按照文件 id 从小到大的顺序处理文件中的记录
+	err := db.loadFileIndexRecords(loadFileIds, startFileId, startOffset, func(result *fileIndexRecords) error {
+		isActiveFile := result.fileId == db.activeFile.FileId
+		for _, record := range result.records {
+			if result.fileId != startFileId || record.pos.Offset >= startOffset {
+				processRecord(record.key, record.typ, record.pos)
 			}
-			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{
-				Fid:    fileId,
-				Offset: offset,
-				Size:   uint32(size),
-			}
-
-			// 解析 Key，拿到事务序列号
-			realKey, seqNo := parseLogRcordKeyWithSeqNo(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// 事务完成，对应事务中的所有数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range txnRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(txnRecords, seqNo)
-				} else {
-					//  事务中的数据还未全部获取，先暂存起来
-					logRecord.Key = realKey
-					txnRecords[seqNo] = append(txnRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
+			// 活跃文件的记录暂存起来，转换成旧文件时写入 hint 文件
+			if isActiveFile && !db.options.ReadOnly {
+				if err := db.appendHintRecord(record.key, record.typ, record.pos); err != nil {
+					return err
 				}
 			}
-
-			// 更新事务序列号
-			if seqNo > curSeqNo {
-				curSeqNo = seqNo
-			}
-
-			// 更新偏移量，下一次从文件新的偏移量处进行读取
-			offset += size
 		}
-
-		// 还需要维护活跃文件的 Offset
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		// 还需要维护活跃文件的 Offset，并处理尾部不完整或损坏的数据
+		if isActiveFile {
+			db.activeFile.WriteOff = result.offset
+			// 只读模式下尾部的数据可能正在写入，下一次 Refresh 从这里继续读取
+			if db.options.ReadOnly {
+				return nil
+			}
+			// 已经写入了结束标识，说明转换成旧文件之后，打开新的活跃文件之前发生了崩溃
+			if result.sealed {
+				return db.retireActiveFile(db.activeFile.FileId + 1)
+			}
+			return db.recoverActiveFile(result.offset, result.tailErr)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// 更新数据库最新事务序列号
 	db.seqNo = curSeqNo
+	if db.options.ReadOnly {
+		db.pendingTxns = txnRecords
+	}
 
 	return nil
 }
@@ -646,7 +1411,15 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	seqNoFile.Codec = db.codec
+	// 文件被截断或者损坏时返回错误，不能当作序列号不存在处理
 	record, _, err := seqNoFile.ReadLogRecord(0)
+	if closeErr := seqNoFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the seq no file: %w", err)
+	}
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
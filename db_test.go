@@ -4,6 +4,10 @@ import (
   "bitcask-go/utils"
   "github.com/stretchr/testify/assert"
   "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
   "testing"
   "time"
 )
@@ -21,6 +25,13 @@ func destroyDB(db *DB) {
   }
 }
 
+// mustStat 获取数据库的统计信息，出错时测试失败
+func mustStat(t *testing.T, db *DB) *Stat {
+  stat, err := db.Stat()
+  assert.Nil(t, err)
+  return stat
+}
+
 func TestOpen(t *testing.T) {
   opts := DefaultOptions
   dir, _ := os.MkdirTemp("", "bitcask-go")
@@ -284,6 +295,110 @@ func TestDB_Close(t *testing.T) {
   assert.Nil(t, err)
 }
 
+func TestDB_UseAfterClose(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-use-after-close")
+  opts.DirPath = dir
+  opts.BlobThreshold = 512
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+
+  for i := 0; i < 100; i++ {
+    assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+  }
+  blob := utils.RandomValue(1024)
+  assert.Nil(t, db.Put([]byte("blob"), blob))
+  // 关闭之前创建的快照和迭代器引用的文件在释放之后才会关闭
+  snap, err := db.NewSnapshot()
+  assert.Nil(t, err)
+  iter := db.NewIterator(DefaultIteratorOptions)
+  iter.Rewind()
+  txn, err := db.Begin(DefaultTxnOptions)
+  assert.Nil(t, err)
+
+  assert.Nil(t, db.Close())
+  assert.Equal(t, ErrDatabaseClosed, db.Close())
+
+  assert.Equal(t, ErrDatabaseClosed, db.Put(utils.GetTestKey(1), []byte("value")))
+  _, err = db.Get(utils.GetTestKey(1))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  assert.Equal(t, ErrDatabaseClosed, db.Delete(utils.GetTestKey(1)))
+  _, err = db.TTL(utils.GetTestKey(1))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  _, err = db.CompareAndSwap(utils.GetTestKey(1), utils.GetTestKey(1), []byte("value"))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  _, err = db.Stat()
+  assert.Equal(t, ErrDatabaseClosed, err)
+  assert.Equal(t, ErrDatabaseClosed, db.Sync())
+  assert.Equal(t, ErrDatabaseClosed, db.Merge())
+  assert.Equal(t, ErrDatabaseClosed, db.Compact())
+  assert.Equal(t, ErrDatabaseClosed, db.CheckpointIndex())
+  assert.Equal(t, ErrDatabaseClosed, db.Backup(filepath.Join(dir, "backup")))
+  assert.Equal(t, ErrDatabaseClosed, db.Fold(func(key []byte, value []byte) bool { return true }))
+  _, _, err = db.GetReader([]byte("blob"))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  assert.Nil(t, db.ListKeys())
+
+  wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+  assert.Equal(t, ErrDatabaseClosed, wb.Put(utils.GetTestKey(1), []byte("value")))
+  assert.Equal(t, ErrDatabaseClosed, wb.Delete(utils.GetTestKey(1)))
+  assert.Equal(t, ErrDatabaseClosed, wb.Commit())
+  _, err = db.Begin(DefaultTxnOptions)
+  assert.Equal(t, ErrDatabaseClosed, err)
+  // 关闭之前开启的事务不能再读写
+  _, err = txn.Get(utils.GetTestKey(1))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  assert.Equal(t, ErrDatabaseClosed, txn.Delete(utils.GetTestKey(1)))
+  assert.Nil(t, txn.Put(utils.GetTestKey(1), []byte("value")))
+  assert.Equal(t, ErrDatabaseClosed, txn.Commit())
+
+  // 关闭之后创建的迭代器中没有数据，不能再创建快照
+  iter2 := db.NewIterator(DefaultIteratorOptions)
+  iter2.Rewind()
+  assert.False(t, iter2.Valid())
+  iter2.Close()
+  _, err = db.NewSnapshot()
+  assert.Equal(t, ErrDatabaseClosed, err)
+
+  assert.True(t, iter.Valid())
+  _, err = iter.Value()
+  assert.Equal(t, ErrDatabaseClosed, err)
+  _, err = snap.Get(utils.GetTestKey(1))
+  assert.Equal(t, ErrDatabaseClosed, err)
+  assert.NotEqual(t, 0, len(db.obsoleteFiles))
+  iter.Close()
+  snap.Release()
+  assert.Equal(t, 0, len(db.obsoleteFiles))
+
+  // 关闭时已经持久化了所有数据，重新打开后可以读取
+  db2, err := Open(opts)
+  assert.Nil(t, err)
+  defer destroyDB(db2)
+  val, err := db2.Get([]byte("blob"))
+  assert.Nil(t, err)
+  assert.Equal(t, blob, val)
+  assert.Equal(t, 101, len(db2.ListKeys()))
+}
+
+func TestOpen_IndexFailed(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-index-failed")
+  defer os.RemoveAll(dir)
+  opts.DirPath = dir
+  opts.IndexerType = BPlusTreeIndex
+
+  // B+ 树索引文件无法打开时返回错误，并释放文件锁
+  assert.Nil(t, os.Mkdir(filepath.Join(dir, "bptree-index"), os.ModePerm))
+  _, err := Open(opts)
+  assert.NotNil(t, err)
+
+  opts.IndexerType = BTreeIndex
+  db, err := Open(opts)
+  assert.Nil(t, err)
+  assert.Nil(t, db.Close())
+}
+
 func TestDB_Sync(t *testing.T) {
   opts := DefaultOptions
   dir, _ := os.MkdirTemp("", "bitcask-go-sync")
@@ -357,7 +472,8 @@ func TestDB_Stat(t *testing.T) {
     assert.Nil(t, err)
   }
 
-  stat := db.Stat()
+  stat, err := db.Stat()
+  assert.Nil(t, err)
   assert.NotNil(t, stat)
 }
 
@@ -386,3 +502,251 @@ func TestDB_Backup(t *testing.T) {
   assert.Nil(t, err)
   assert.NotNil(t, db2)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  // 1.未过期的数据可以正常读取
+  err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), time.Hour)
+  assert.Nil(t, err)
+  val1, err := db.Get(utils.GetTestKey(1))
+  assert.Nil(t, err)
+  assert.NotNil(t, val1)
+
+  // 2.过期的数据不可见
+  err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), time.Millisecond*50)
+  assert.Nil(t, err)
+  err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+  assert.Nil(t, err)
+  time.Sleep(time.Millisecond * 100)
+  _, err = db.Get(utils.GetTestKey(2))
+  assert.Equal(t, ErrKeyNotFound, err)
+  assert.Equal(t, 2, len(db.ListKeys()))
+
+  var count int
+  err = db.Fold(func(key []byte, value []byte) bool {
+    count++
+    return true
+  })
+  assert.Nil(t, err)
+  assert.Equal(t, 2, count)
+
+  iter := db.NewIterator(DefaultIteratorOptions)
+  count = 0
+  for iter.Rewind(); iter.Valid(); iter.Next() {
+    assert.NotEqual(t, utils.GetTestKey(2), iter.Key())
+    count++
+  }
+  iter.Close()
+  assert.Equal(t, 2, count)
+
+  // 3.重启后过期时间依然生效
+  err = db.Close()
+  assert.Nil(t, err)
+  db2, err := Open(opts)
+  assert.Nil(t, err)
+  _, err = db2.Get(utils.GetTestKey(2))
+  assert.Equal(t, ErrKeyNotFound, err)
+  ttl, err := db2.TTL(utils.GetTestKey(1))
+  assert.Nil(t, err)
+  assert.True(t, ttl > 0 && ttl <= time.Hour)
+  err = db2.Close()
+  assert.Nil(t, err)
+}
+
+func TestDB_TTL_Persist(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-persist")
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  // 没有设置过期时间
+  err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+  assert.Nil(t, err)
+  ttl, err := db.TTL(utils.GetTestKey(1))
+  assert.Nil(t, err)
+  assert.Equal(t, time.Duration(0), ttl)
+
+  // key 不存在
+  _, err = db.TTL(utils.GetTestKey(2))
+  assert.Equal(t, ErrKeyNotFound, err)
+  err = db.Persist(utils.GetTestKey(2))
+  assert.Equal(t, ErrKeyNotFound, err)
+
+  // 移除过期时间
+  val := utils.RandomValue(24)
+  err = db.PutWithTTL(utils.GetTestKey(3), val, time.Millisecond*100)
+  assert.Nil(t, err)
+  err = db.Persist(utils.GetTestKey(3))
+  assert.Nil(t, err)
+  time.Sleep(time.Millisecond * 150)
+  val2, err := db.Get(utils.GetTestKey(3))
+  assert.Nil(t, err)
+  assert.Equal(t, val, val2)
+  ttl, err = db.TTL(utils.GetTestKey(3))
+  assert.Nil(t, err)
+  assert.Equal(t, time.Duration(0), ttl)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  // 1.key 不存在
+  ok, err := db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+  assert.Nil(t, err)
+  assert.False(t, ok)
+
+  // 2.旧值不匹配
+  err = db.Put(utils.GetTestKey(1), []byte("a"))
+  assert.Nil(t, err)
+  ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("c"), []byte("b"))
+  assert.Nil(t, err)
+  assert.False(t, ok)
+
+  // 3.旧值匹配
+  ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+  assert.Nil(t, err)
+  assert.True(t, ok)
+  val, err := db.Get(utils.GetTestKey(1))
+  assert.Nil(t, err)
+  assert.Equal(t, []byte("b"), val)
+
+  // 4.并发递增计数器
+  err = db.Put(utils.GetTestKey(2), []byte("0"))
+  assert.Nil(t, err)
+  wg := new(sync.WaitGroup)
+  for i := 0; i < 4; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for j := 0; j < 100; {
+        old, err := db.Get(utils.GetTestKey(2))
+        assert.Nil(t, err)
+        n, _ := strconv.Atoi(string(old))
+        ok, err := db.CompareAndSwap(utils.GetTestKey(2), old, []byte(strconv.Itoa(n+1)))
+        assert.Nil(t, err)
+        if ok {
+          j++
+        }
+      }
+    }()
+  }
+  wg.Wait()
+  val, err = db.Get(utils.GetTestKey(2))
+  assert.Nil(t, err)
+  assert.Equal(t, []byte("400"), val)
+}
+
+func TestDB_PutIfAbsent_DeleteIfEqual(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("leader-1"))
+  assert.Nil(t, err)
+  assert.True(t, ok)
+  ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("leader-2"))
+  assert.Nil(t, err)
+  assert.False(t, ok)
+  val, err := db.Get(utils.GetTestKey(1))
+  assert.Nil(t, err)
+  assert.Equal(t, []byte("leader-1"), val)
+
+  // 过期的 key 视为不存在
+  err = db.PutWithTTL(utils.GetTestKey(2), []byte("expired"), time.Millisecond*10)
+  assert.Nil(t, err)
+  time.Sleep(time.Millisecond * 20)
+  ok, err = db.PutIfAbsent(utils.GetTestKey(2), []byte("new"))
+  assert.Nil(t, err)
+  assert.True(t, ok)
+
+  ok, err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("leader-2"))
+  assert.Nil(t, err)
+  assert.False(t, ok)
+  ok, err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("leader-1"))
+  assert.Nil(t, err)
+  assert.True(t, ok)
+  _, err = db.Get(utils.GetTestKey(1))
+  assert.Equal(t, ErrKeyNotFound, err)
+  ok, err = db.DeleteIfEqual(utils.GetTestKey(1), []byte("leader-1"))
+  assert.Nil(t, err)
+  assert.False(t, ok)
+}
+
+func TestDB_Get_Concurrent(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-get-concurrent")
+  opts.DirPath = dir
+  opts.DataFileSize = 32 * 1024
+  opts.DataFileMergeRatio = 0
+  opts.BlobThreshold = 512
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+
+  // value 由 key 决定，重复写入也不会改变读到的内容
+  value := func(i int) []byte {
+    v := []byte(strings.Repeat(strconv.Itoa(i), 64))
+    if i%10 == 0 {
+      v = []byte(strings.Repeat(strconv.Itoa(i), 256))
+    }
+    return v
+  }
+  for i := 0; i < 2000; i++ {
+    assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+  }
+
+  // 读操作和写入、merge、compaction 同时进行，读到的总是正确的数据
+  stop := make(chan struct{})
+  wg := new(sync.WaitGroup)
+  for r := 0; r < 4; r++ {
+    wg.Add(1)
+    go func(r int) {
+      defer wg.Done()
+      for n := r; ; n += 7 {
+        select {
+        case <-stop:
+          return
+        default:
+        }
+        i := n % 2000
+        val, err := db.Get(utils.GetTestKey(i))
+        assert.Nil(t, err)
+        assert.Equal(t, value(i), val)
+      }
+    }(r)
+  }
+
+  for round := 0; round < 3; round++ {
+    for i := 0; i < 2000; i += 2 {
+      assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+    }
+    assert.Nil(t, db.Merge())
+    for i := 1; i < 2000; i += 2 {
+      assert.Nil(t, db.Put(utils.GetTestKey(i), value(i)))
+    }
+    assert.Nil(t, db.Compact())
+  }
+  close(stop)
+  wg.Wait()
+  assert.Equal(t, 0, pinnedFileCount(db))
+}
@@ -0,0 +1,109 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rotatingKeyProvider 测试密钥轮换使用的 KeyProvider
+type rotatingKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *rotatingKeyProvider) CurrentKeyId() uint32 {
+	return p.current
+}
+
+func (p *rotatingKeyProvider) Key(keyId uint32) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, data.ErrDecryptFailed
+	}
+	return key, nil
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 32)
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.CheckpointIndex())
+	assert.Nil(t, db.Close())
+
+	// 目录中所有的文件都不包含明文数据
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		buf, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(buf, utils.GetTestKey(500)), entry.Name())
+		assert.False(t, bytes.Contains(buf, []byte("secret-value")), entry.Name())
+	}
+
+	checkData := func(db *DB) {
+		for i := 100; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("secret-value"), val)
+		}
+	}
+
+	// 没有密钥或者密钥错误时无法打开
+	noKeyOpts := opts
+	noKeyOpts.EncryptionKey = nil
+	_, err = Open(noKeyOpts)
+	assert.Equal(t, data.ErrEncryptionKeyRequired, err)
+	wrongKeyOpts := opts
+	wrongKeyOpts.EncryptionKey = bytes.Repeat([]byte("x"), 32)
+	_, err = Open(wrongKeyOpts)
+	assert.Equal(t, data.ErrDecryptFailed, err)
+
+	// 轮换密钥，merge 之后所有数据都使用新的密钥加密
+	provider := &rotatingKeyProvider{
+		current: 1,
+		keys: map[uint32][]byte{
+			0: opts.EncryptionKey,
+			1: bytes.Repeat([]byte("n"), 16),
+		},
+	}
+	rotateOpts := opts
+	rotateOpts.EncryptionKey = nil
+	rotateOpts.KeyProvider = provider
+	db, err = Open(rotateOpts)
+	assert.Nil(t, err)
+	checkData(db)
+	assert.Nil(t, db.Put([]byte("new-key"), []byte("new-value")))
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	delete(provider.keys, 0)
+	db, err = Open(rotateOpts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	checkData(db)
+	val, err := db.Get([]byte("new-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+}
@@ -13,4 +13,19 @@ var (
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys read have been modified")
+	ErrTxnFinished            = errors.New("the transaction has been committed or rolled back")
+	ErrActiveFileCorrupted    = errors.New("the tail of the active data file is corrupted")
+	ErrManifestCorrupted      = errors.New("the MANIFEST file is corrupted")
+	ErrUnsupportedFormat      = errors.New("the format version of the database directory is not supported")
+	ErrIndexTypeMismatch      = errors.New("the index type does not match the database directory")
+	ErrDataFileMissing        = errors.New("data file listed in the MANIFEST is missing")
+	ErrInvalidValueSize       = errors.New("the value size is invalid")
+	ErrInvalidRange           = errors.New("the offset or length of the range is invalid")
+	ErrValueReaderClosed      = errors.New("the value reader has been closed")
+	ErrReadOnly               = errors.New("the database is opened in read-only mode")
+	ErrDatabaseClosed         = errors.New("the database has been closed")
+	ErrMergeFileIdsExhausted  = errors.New("the merged data files exceed the file ids reserved for merge")
+	ErrSeqNoNotAvailable      = errors.New("cannot begin transaction, seq no file not exists")
 )
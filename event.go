@@ -0,0 +1,190 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger 存储引擎输出日志使用的接口，和 log/slog 中 *slog.Logger 的方法一致，可以直接传入 *slog.Logger
+// args 是交替出现的 key 和 value
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// nopLogger 不输出任何日志，没有配置 Logger 时使用
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+type LogLevel = int8
+
+const (
+	// LogLevelDebug 调试日志，例如活跃文件的转换
+	LogLevelDebug LogLevel = iota
+
+	// LogLevelInfo 打开、关闭数据库以及 merge 等操作
+	LogLevelInfo
+
+	// LogLevelWarn 不影响正常运行的异常，例如持久化较慢、启动时丢弃了损坏的数据
+	LogLevelWarn
+
+	// LogLevelError 操作失败
+	LogLevelError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// stdLogger 将日志输出到标准库的 *log.Logger
+type stdLogger struct {
+	logger *log.Logger
+	level  LogLevel
+}
+
+// NewStdLogger 返回将不低于 level 的日志输出到标准库 *log.Logger 的 Logger，格式为 "LEVEL msg key=value ..."
+func NewStdLogger(logger *log.Logger, level LogLevel) Logger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Debug(msg string, args ...any) { l.log(LogLevelDebug, msg, args) }
+func (l *stdLogger) Info(msg string, args ...any)  { l.log(LogLevelInfo, msg, args) }
+func (l *stdLogger) Warn(msg string, args ...any)  { l.log(LogLevelWarn, msg, args) }
+func (l *stdLogger) Error(msg string, args ...any) { l.log(LogLevelError, msg, args) }
+
+func (l *stdLogger) log(level LogLevel, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(logLevelNames[level])
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&sb, " %v", args[i])
+		}
+	}
+	l.logger.Print(sb.String())
+}
+
+// EventListener 接收存储引擎内部事件的回调接口，可以用来将事件接入监控系统
+// 回调在触发事件的协程中同步执行，执行时可能持有数据库的互斥锁，因此不能调用数据库的方法，并且应该尽快返回
+// 只关心部分事件时可以嵌入 NoopEventListener
+type EventListener interface {
+	// OnFileRotated 活跃数据文件写满，转换成旧数据文件并打开了新的活跃文件
+	OnFileRotated(info FileRotatedInfo)
+
+	// OnMergeStart merge 开始，已经确定了参与 merge 的数据文件
+	OnMergeStart(info MergeStartInfo)
+
+	// OnMergeEnd merge 结束，只有调用过 OnMergeStart 的 merge 才会调用
+	OnMergeEnd(info MergeEndInfo)
+
+	// OnRecoveryTruncated 启动时活跃文件尾部存在不完整或损坏的数据，已经按照 RecoveryMode 截断或跳过
+	OnRecoveryTruncated(report RecoveryReport)
+
+	// OnSyncSlow 一次持久化的耗时超过了 SlowSyncThreshold
+	OnSyncSlow(info SyncSlowInfo)
+}
+
+// FileRotatedInfo 活跃数据文件转换的信息
+type FileRotatedInfo struct {
+	OldFileId   uint32 // 转换成旧数据文件的文件 id
+	OldFileSize int64  // 旧数据文件的大小，包括文件结束标识
+	NewFileId   uint32 // 新的活跃文件 id
+}
+
+// MergeStartInfo merge 开始时的信息
+type MergeStartInfo struct {
+	MergeFileNum    int   // 参与 merge 的数据文件数量
+	ReclaimableSize int64 // 开始时可以回收的无效数据大小
+}
+
+// MergeEndInfo merge 结束时的信息
+type MergeEndInfo struct {
+	Duration       time.Duration // merge 的耗时
+	ReclaimedBytes int64         // 删除的数据文件和 blob 文件的大小减去 merge 生成的数据文件的大小，可能为负数
+	Err            error         // merge 失败的原因，成功时为空
+}
+
+// SyncSlowInfo 持久化较慢的文件信息
+type SyncSlowInfo struct {
+	FileId   uint32        // 持久化的文件 id
+	Blob     bool          // 是否是 blob 文件
+	Duration time.Duration // 持久化的耗时
+}
+
+// NoopEventListener 不处理任何事件的 EventListener，可以嵌入到自定义的 EventListener 中
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFileRotated(FileRotatedInfo)      {}
+func (NoopEventListener) OnMergeStart(MergeStartInfo)        {}
+func (NoopEventListener) OnMergeEnd(MergeEndInfo)            {}
+func (NoopEventListener) OnRecoveryTruncated(RecoveryReport) {}
+func (NoopEventListener) OnSyncSlow(SyncSlowInfo)            {}
+
+// onFileRotated 记录活跃文件的转换，需要持有互斥锁
+func (db *DB) onFileRotated(info FileRotatedInfo) {
+	db.logger.Debug("data file rotated", "dir", db.options.DirPath,
+		"oldFileId", info.OldFileId, "oldFileSize", info.OldFileSize, "newFileId", info.NewFileId)
+	db.metrics.fileRotations.Add(1)
+	db.listener.OnFileRotated(info)
+}
+
+// onMergeStart 记录 merge 开始
+func (db *DB) onMergeStart(info MergeStartInfo) {
+	db.logger.Info("merge started", "dir", db.options.DirPath,
+		"mergeFileNum", info.MergeFileNum, "reclaimableSize", info.ReclaimableSize)
+	db.listener.OnMergeStart(info)
+}
+
+// onMergeEnd 记录 merge 结束，失败时输出错误日志
+func (db *DB) onMergeEnd(info MergeEndInfo) {
+	if info.Err != nil {
+		db.logger.Error("merge failed", "dir", db.options.DirPath, "duration", info.Duration, "err", info.Err)
+	} else {
+		db.logger.Info("merge finished", "dir", db.options.DirPath, "duration", info.Duration,
+			"reclaimedBytes", info.ReclaimedBytes)
+	}
+	db.metrics.mergeDuration.observe(info.Duration.Seconds())
+	// merge 生成的文件比删除的文件大时 ReclaimedBytes 为负数，计数器只累计正数
+	if info.ReclaimedBytes > 0 {
+		db.metrics.mergeReclaimedBytes.Add(info.ReclaimedBytes)
+	}
+	db.listener.OnMergeEnd(info)
+}
+
+// onRecoveryTruncated 记录启动时对活跃文件尾部损坏数据的处理
+func (db *DB) onRecoveryTruncated(report RecoveryReport) {
+	db.logger.Warn("active data file tail recovered", "dir", db.options.DirPath,
+		"fileId", report.FileId, "validOffset", report.ValidOffset,
+		"discardedBytes", report.DiscardedBytes, "mode", report.Mode, "reason", report.Reason)
+	db.listener.OnRecoveryTruncated(report)
+}
+
+// syncFile 持久化文件，耗时超过 SlowSyncThreshold 时通知 OnSyncSlow
+func (db *DB) syncFile(file *data.DataFile, blob bool) error {
+	start := time.Now()
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	duration := time.Since(start)
+	db.metrics.syncDuration.observe(duration.Seconds())
+	if threshold := db.options.SlowSyncThreshold; threshold > 0 && duration >= threshold {
+		info := SyncSlowInfo{FileId: file.FileId, Blob: blob, Duration: duration}
+		db.logger.Warn("slow sync", "dir", db.options.DirPath,
+			"fileId", info.FileId, "blob", info.Blob, "duration", info.Duration)
+		db.listener.OnSyncSlow(info)
+	}
+	return nil
+}
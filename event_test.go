@@ -0,0 +1,117 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordListener 记录收到的事件
+type recordListener struct {
+	NoopEventListener
+	mu         sync.Mutex
+	rotated    []FileRotatedInfo
+	mergeStart []MergeStartInfo
+	mergeEnd   []MergeEndInfo
+	recovered  []RecoveryReport
+	slowSyncs  []SyncSlowInfo
+}
+
+func (l *recordListener) OnFileRotated(info FileRotatedInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotated = append(l.rotated, info)
+}
+
+func (l *recordListener) OnMergeStart(info MergeStartInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeStart = append(l.mergeStart, info)
+}
+
+func (l *recordListener) OnMergeEnd(info MergeEndInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeEnd = append(l.mergeEnd, info)
+}
+
+func (l *recordListener) OnRecoveryTruncated(report RecoveryReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recovered = append(l.recovered, report)
+}
+
+func (l *recordListener) OnSyncSlow(info SyncSlowInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.slowSyncs = append(l.slowSyncs, info)
+}
+
+func TestDB_EventListener(t *testing.T) {
+	opts, fileId, validOffset := prepareTornDB(t, make([]byte, 32))
+	listener := &recordListener{}
+	var logBuf bytes.Buffer
+	opts.EventListener = listener
+	opts.Logger = NewStdLogger(log.New(&logBuf, "", 0), LogLevelDebug)
+	opts.DataFileMergeRatio = 0
+	opts.SlowSyncThreshold = 1 // 每次持久化都超过阈值
+
+	// 启动时截断了活跃文件尾部的数据
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 1, len(listener.recovered))
+	assert.Equal(t, fileId, listener.recovered[0].FileId)
+	assert.Equal(t, validOffset, listener.recovered[0].ValidOffset)
+	assert.Contains(t, logBuf.String(), "WARN active data file tail recovered")
+	assert.Contains(t, logBuf.String(), "INFO database opened dir="+opts.DirPath)
+
+	// 写满活跃文件时转换
+	activeFileId := db.activeFile.FileId
+	for i := 0; db.activeFile.FileId == activeFileId; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Equal(t, 1, len(listener.rotated))
+	assert.Equal(t, activeFileId, listener.rotated[0].OldFileId)
+	assert.Equal(t, db.activeFile.FileId, listener.rotated[0].NewFileId)
+	assert.Equal(t, db.olderFiles[activeFileId].WriteOff, listener.rotated[0].OldFileSize)
+	assert.NotEqual(t, 0, len(listener.slowSyncs))
+	assert.Equal(t, activeFileId, listener.slowSyncs[0].FileId)
+	assert.False(t, listener.slowSyncs[0].Blob)
+
+	// merge 的开始和结束，临时数据库的事件不会通知
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, 1, len(listener.mergeStart))
+	assert.NotEqual(t, 0, listener.mergeStart[0].MergeFileNum)
+	assert.Equal(t, 1, len(listener.mergeEnd))
+	assert.Nil(t, listener.mergeEnd[0].Err)
+	assert.Equal(t, 2, len(listener.rotated))
+	assert.Contains(t, logBuf.String(), "INFO merge finished")
+
+	// 没有达到阈值的 merge 不会开始
+	db.options.DataFileMergeRatio = 1
+	assert.Equal(t, ErrMergeRatioUnreached, db.Merge())
+	assert.Equal(t, 1, len(listener.mergeStart))
+	assert.Equal(t, 1, len(listener.mergeEnd))
+
+	assert.Nil(t, db.Close())
+	assert.Contains(t, logBuf.String(), "INFO database closed")
+}
+
+func TestNewStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LogLevelInfo)
+	logger.Debug("debug message")
+	logger.Info("info message", "key", 1, "odd")
+	logger.Error("error message", "err", ErrKeyNotFound)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		"INFO info message key=1 odd",
+		"ERROR error message err=key not found in database",
+	}, lines)
+}
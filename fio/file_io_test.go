@@ -91,3 +91,30 @@ func TestFileIO_Close(t *testing.T) {
   err = fio.Close()
   assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+  path := filepath.Join("/tmp", "ro.data")
+  defer destroyFile(path)
+
+  // 文件不存在时不会创建
+  _, err := NewReadOnlyFileIOManager(path)
+  assert.True(t, os.IsNotExist(err))
+  _, err = os.Stat(path)
+  assert.True(t, os.IsNotExist(err))
+
+  fio, err := NewFileIOManager(path)
+  assert.Nil(t, err)
+  _, err = fio.Write([]byte("key-a"))
+  assert.Nil(t, err)
+
+  roFio, err := NewReadOnlyFileIOManager(path)
+  assert.Nil(t, err)
+  b := make([]byte, 5)
+  _, err = roFio.Read(b, 0)
+  assert.Nil(t, err)
+  assert.Equal(t, []byte("key-a"), b)
+  _, err = roFio.Write([]byte("key-b"))
+  assert.NotNil(t, err)
+  assert.Nil(t, roFio.Close())
+  assert.Nil(t, fio.Close())
+}
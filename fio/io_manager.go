@@ -12,6 +12,9 @@ const (
 
 	// MemoryMap 内存文件映射
 	MemoryMap
+
+	// ReadOnlyFIO 只读的标准文件 IO，文件不存在时不会创建
+	ReadOnlyFIO
 )
 
 // IOManager 一个 IO 管理的抽象接口，将各种 IO 接口封装在一起，支持不同的文件 IO 实现，目前只实现了标准系统文件 IO
@@ -40,6 +43,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,577 @@
+// Package fsck 离线检查和修复 bitcask 数据目录
+// 检查时数据库不能处于打开状态
+package fsck
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 和 bitcask 数据目录中的文件锁、merge 目录的命名保持一致
+	fileLockName = "flock"
+	mergeDirName = "-merge"
+)
+
+var (
+	ErrDatabaseIsUsing = errors.New("the database directory is used by another process")
+	ErrRepairDirExists = errors.New("the repair directory already exists and is not empty")
+)
+
+// Issue 检查时发现的一个问题
+type Issue struct {
+	File    string // 出现问题的文件名
+	Offset  int64  // 问题在文件中的位置，和位置无关时为 -1
+	Length  int64  // 受影响的字节数
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Offset < 0 {
+		return fmt.Sprintf("%s: %s", i.File, i.Message)
+	}
+	return fmt.Sprintf("%s@%d(+%d): %s", i.File, i.Offset, i.Length, i.Message)
+}
+
+// Report 检查的结果
+type Report struct {
+	DataFiles      int     // 数据文件的数量
+	BlobFiles      int     // blob 文件的数量
+	Records        int     // 完整有效的记录数量
+	BadBytes       int64   // 损坏区域的总字节数
+	IncompleteTxns int     // 没有完成标识的事务数量
+	Errors         []Issue // 数据损坏等需要修复的问题
+	Warnings       []Issue // 不影响数据正确性的问题，例如崩溃时没有完成的事务
+}
+
+// OK 数据目录中没有需要修复的问题
+func (r *Report) OK() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Report) addError(file string, offset, length int64, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, Issue{File: file, Offset: offset, Length: length, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) addWarning(file string, offset, length int64, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, Issue{File: file, Offset: offset, Length: length, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check 检查数据目录中的所有文件
+func Check(dirPath string) (*Report, error) {
+	return run(dirPath, "")
+}
+
+// Repair 检查数据目录，并将修复后的数据写入新的目录 repairPath，原目录保持不变
+// 数据文件中损坏的区域会被跳过，其余记录保持原来的顺序写入同样 id 的数据文件中
+// 记录的位置发生了变化，因此 hint 文件、merge 完成标识、index checkpoint 和 B+ 树索引都不会被拷贝，打开时会从数据文件中重建索引
+// MANIFEST 也不会被拷贝，打开修复后的目录时会根据其中的数据文件重新生成
+// blob 文件按照位置被数据文件中的记录引用，原样拷贝到修复目录
+func Repair(dirPath, repairPath string) (*Report, error) {
+	if entries, err := os.ReadDir(repairPath); err == nil && len(entries) > 0 {
+		return nil, ErrRepairDirExists
+	}
+	if err := os.MkdirAll(repairPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return run(dirPath, repairPath)
+}
+
+// txnState 一个事务已经读到的记录
+type txnState struct {
+	file    string
+	offset  int64
+	records int
+}
+
+// checker 检查一个数据目录
+type checker struct {
+	dirPath    string
+	repairPath string
+	report     *Report
+	fileIds    []uint32
+	dataFiles  map[uint32]*data.DataFile
+	blobFiles  map[uint32]*data.DataFile
+	txns       map[uint64]*txnState
+}
+
+func run(dirPath, repairPath string) (*Report, error) {
+	// 数据库正在使用时不能检查
+	fileLock := flock.New(filepath.Join(dirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	c := &checker{
+		dirPath:    dirPath,
+		repairPath: repairPath,
+		report:     &Report{},
+		dataFiles:  make(map[uint32]*data.DataFile),
+		blobFiles:  make(map[uint32]*data.DataFile),
+		txns:       make(map[uint64]*txnState),
+	}
+	defer c.close()
+
+	if err := c.openDataFiles(); err != nil {
+		return nil, err
+	}
+	if err := c.openBlobFiles(); err != nil {
+		return nil, err
+	}
+	for _, fid := range c.fileIds {
+		if err := c.checkDataFile(fid); err != nil {
+			return nil, err
+		}
+	}
+	c.checkTxns()
+	if err := c.checkMergeHintFile(); err != nil {
+		return nil, err
+	}
+	if err := c.checkFileHintFiles(); err != nil {
+		return nil, err
+	}
+	if err := c.checkSeqNoFile(); err != nil {
+		return nil, err
+	}
+	c.checkMergeDir()
+	return c.report, nil
+}
+
+func (c *checker) close() {
+	for _, dataFile := range c.dataFiles {
+		_ = dataFile.Close()
+	}
+	for _, blobFile := range c.blobFiles {
+		_ = blobFile.Close()
+	}
+}
+
+// openDataFiles 打开目录中的所有数据文件
+func (c *checker) openDataFiles() error {
+	entries, err := os.ReadDir(c.dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(name, data.DataFileNameSuffix))
+		if err != nil {
+			c.report.addError(name, -1, 0, "invalid data file name")
+			continue
+		}
+		dataFile, err := data.OpenDataFile(c.dirPath, uint32(fileId), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		c.fileIds = append(c.fileIds, uint32(fileId))
+		c.dataFiles[uint32(fileId)] = dataFile
+	}
+	sort.Slice(c.fileIds, func(i, j int) bool {
+		return c.fileIds[i] < c.fileIds[j]
+	})
+	c.report.DataFiles = len(c.fileIds)
+	return nil
+}
+
+// openBlobFiles 打开目录中的所有 blob 文件，需要修复时原样拷贝到修复目录
+func (c *checker) openBlobFiles() error {
+	entries, err := os.ReadDir(c.dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.BlobFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(name, data.BlobFileNameSuffix))
+		if err != nil {
+			c.report.addError(name, -1, 0, "invalid blob file name")
+			continue
+		}
+		if c.repairPath != "" {
+			buf, err := os.ReadFile(filepath.Join(c.dirPath, name))
+			if err != nil {
+				return err
+			}
+			if err := utils.WriteFileSync(filepath.Join(c.repairPath, name), buf, fio.DataFilePerm); err != nil {
+				return err
+			}
+		}
+		blobFile, err := data.OpenBlobFile(c.dirPath, uint32(fileId), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		c.blobFiles[uint32(fileId)] = blobFile
+	}
+	c.report.BlobFiles = len(c.blobFiles)
+	return nil
+}
+
+// checkDataFile 检查一个数据文件中的所有记录，需要修复时将完整的记录写入修复目录
+func (c *checker) checkDataFile(fileId uint32) error {
+	dataFile := c.dataFiles[fileId]
+	name := filepath.Base(data.GetDataFileName(c.dirPath, fileId))
+
+	var repairFile *data.DataFile
+	if c.repairPath != "" {
+		var err error
+		if repairFile, err = data.OpenDataFile(c.repairPath, fileId, fio.StandardFIO); err != nil {
+			return err
+		}
+		defer func() {
+			_ = repairFile.Close()
+		}()
+	}
+
+	err := scanRecords(dataFile, func(record *data.LogRecord, offset, size int64) error {
+		c.report.Records++
+		c.checkRecord(name, record, offset, size)
+		if repairFile != nil {
+			encRecord, _ := data.EncodeLogRecord(record)
+			return repairFile.Write(encRecord)
+		}
+		return nil
+	}, func(offset, length int64, cause error) {
+		c.report.BadBytes += length
+		c.report.addError(name, offset, length, "%s, skipped", describeBadRegion(cause))
+	})
+	if err != nil {
+		return err
+	}
+	if repairFile != nil {
+		return repairFile.Sync()
+	}
+	return nil
+}
+
+// checkRecord 检查记录的类型，并跟踪事务是否完整
+func (c *checker) checkRecord(name string, record *data.LogRecord, offset, size int64) {
+	if record.Compression == data.CompressionBlobRef {
+		c.checkBlobRef(name, record, offset, size)
+	}
+	_, seqNo := bitcask.ParseLogRecordKey(record.Key)
+	switch record.Type {
+	case data.LogRecordNormal, data.LogRecordDeleted:
+		if seqNo == 0 {
+			return
+		}
+		txn, ok := c.txns[seqNo]
+		if !ok {
+			txn = &txnState{file: name, offset: offset}
+			c.txns[seqNo] = txn
+		}
+		txn.records++
+	case data.LogRecordTxnFinished:
+		if seqNo == 0 {
+			c.report.addError(name, offset, size, "txn finished record without seq number")
+			return
+		}
+		delete(c.txns, seqNo)
+	default:
+		c.report.addError(name, offset, size, "unknown record type %d", record.Type)
+	}
+}
+
+// checkBlobRef 检查记录引用的 blob 是否都可以完整读取，分块存储的 value 检查每一个分块
+func (c *checker) checkBlobRef(name string, record *data.LogRecord, offset, size int64) {
+	ref, err := data.DecodeBlobRef(record.Value)
+	if err != nil {
+		c.report.addError(name, offset, size, "%v", err)
+		return
+	}
+	for _, blobPos := range ref.Chunks {
+		blobFile, ok := c.blobFiles[blobPos.Fid]
+		if !ok {
+			c.report.addError(name, offset, size, "references missing blob file %d", blobPos.Fid)
+			return
+		}
+		blobRecord, blobSize, err := blobFile.ReadLogRecord(blobPos.Offset)
+		if err != nil {
+			c.report.addError(name, offset, size, "references unreadable blob at %d of blob file %d: %v", blobPos.Offset, blobPos.Fid, err)
+			return
+		}
+		// merge 重写记录时会去掉事务序列号，blob 中的 key 保持不变，只比较实际的 key
+		blobKey, _ := bitcask.ParseLogRecordKey(blobRecord.Key)
+		realKey, _ := bitcask.ParseLogRecordKey(record.Key)
+		if string(blobKey) != string(realKey) || int64(blobPos.Size) != blobSize {
+			c.report.addError(name, offset, size, "references a different blob at %d of blob file %d", blobPos.Offset, blobPos.Fid)
+			return
+		}
+	}
+}
+
+// checkTxns 没有完成标识的事务在打开数据库时会被忽略，通常是写入事务时发生了崩溃
+func (c *checker) checkTxns() {
+	seqNos := make([]uint64, 0, len(c.txns))
+	for seqNo := range c.txns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool {
+		return seqNos[i] < seqNos[j]
+	})
+	for _, seqNo := range seqNos {
+		txn := c.txns[seqNo]
+		c.report.addWarning(txn.file, txn.offset, 0,
+			"txn %d has %d records but no finished record, it will be ignored", seqNo, txn.records)
+	}
+	c.report.IncompleteTxns = len(seqNos)
+}
+
+// checkMergeHintFile 检查 merge 生成的 hint 文件，每条索引都要指向数据文件中 key 相同的记录
+func (c *checker) checkMergeHintFile() error {
+	name := data.HintFileName
+	if _, err := os.Stat(filepath.Join(c.dirPath, name)); os.IsNotExist(err) {
+		return nil
+	}
+
+	// 参与了 merge 的文件 id 都小于 merge 完成标识中记录的文件 id
+	nonMergeFileId, hasMergeFin, err := c.checkMergeFinishedFile()
+	if err != nil {
+		return err
+	}
+
+	hintFile, err := data.OpenHintFile(c.dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	return scanRecords(hintFile, func(record *data.LogRecord, offset, size int64) error {
+		pos := data.DecodeLogRecordPos(record.Value)
+		if hasMergeFin && pos.Fid >= nonMergeFileId {
+			c.report.addError(name, offset, size, "key %q points to file %d which did not take part in the merge", record.Key, pos.Fid)
+			return nil
+		}
+		dataFile, ok := c.dataFiles[pos.Fid]
+		if !ok {
+			// 文件可能已经被 compaction 删除，其中的有效数据已经重写到了更新的文件中
+			c.report.addWarning(name, offset, size, "key %q points to missing data file %d", record.Key, pos.Fid)
+			return nil
+		}
+		c.checkHintEntry(name, offset, size, dataFile, pos, func(dataRecord *data.LogRecord) bool {
+			realKey, _ := bitcask.ParseLogRecordKey(dataRecord.Key)
+			return string(realKey) == string(record.Key)
+		})
+		return nil
+	}, func(offset, length int64, cause error) {
+		c.report.addError(name, offset, length, "%s", describeBadRegion(cause))
+	})
+}
+
+// checkMergeFinishedFile 检查 merge 完成标识，返回第一个没有参与 merge 的文件 id
+func (c *checker) checkMergeFinishedFile() (uint32, bool, error) {
+	name := data.MergeFinishedFileName
+	if _, err := os.Stat(filepath.Join(c.dirPath, name)); os.IsNotExist(err) {
+		c.report.addWarning(data.HintFileName, -1, 0, "hint file exists without %s file", name)
+		return 0, false, nil
+	}
+	mergeFinFile, err := data.OpenMergeFinishedFile(c.dirPath)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = mergeFinFile.Close()
+	}()
+	record, _, err := mergeFinFile.ReadLogRecord(0)
+	if err != nil {
+		c.report.addError(name, 0, 0, "failed to read record: %v", err)
+		return 0, false, nil
+	}
+	fileId, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		c.report.addError(name, 0, 0, "invalid non-merge file id %q", record.Value)
+		return 0, false, nil
+	}
+	return uint32(fileId), true, nil
+}
+
+// checkFileHintFiles 检查每个数据文件对应的 hint 文件，每条记录都要和数据文件中的记录一一对应
+func (c *checker) checkFileHintFiles() error {
+	entries, err := os.ReadDir(c.dirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.HintFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(name, data.HintFileNameSuffix))
+		if err != nil {
+			c.report.addWarning(name, -1, 0, "invalid hint file name")
+			continue
+		}
+		dataFile, ok := c.dataFiles[uint32(fileId)]
+		if !ok {
+			c.report.addWarning(name, -1, 0, "hint file without data file")
+			continue
+		}
+
+		hintFile, err := data.OpenDataHintFile(c.dirPath, uint32(fileId))
+		if err != nil {
+			return err
+		}
+		err = scanRecords(hintFile, func(record *data.LogRecord, offset, size int64) error {
+			pos := data.DecodeLogRecordPos(record.Value)
+			if pos.Fid != uint32(fileId) {
+				c.report.addError(name, offset, size, "key %q points to another data file %d", record.Key, pos.Fid)
+				return nil
+			}
+			c.checkHintEntry(name, offset, size, dataFile, pos, func(dataRecord *data.LogRecord) bool {
+				return string(dataRecord.Key) == string(record.Key) && dataRecord.Type == record.Type
+			})
+			return nil
+		}, func(offset, length int64, cause error) {
+			c.report.addError(name, offset, length, "%s, the data file will be scanned instead", describeBadRegion(cause))
+		})
+		_ = hintFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHintEntry 检查 hint 中的一条索引是否指向了数据文件中对应的记录
+func (c *checker) checkHintEntry(name string, offset, size int64, dataFile *data.DataFile,
+	pos *data.LogRecordPos, match func(*data.LogRecord) bool) {
+	dataRecord, recordSize, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		c.report.addError(name, offset, size, "points to unreadable record at %d of file %d: %v", pos.Offset, pos.Fid, err)
+		return
+	}
+	if !match(dataRecord) {
+		c.report.addError(name, offset, size, "points to a different record at %d of file %d", pos.Offset, pos.Fid)
+		return
+	}
+	if pos.Size != 0 && int64(pos.Size) != recordSize {
+		c.report.addError(name, offset, size, "record size %d does not match %d at %d of file %d", pos.Size, recordSize, pos.Offset, pos.Fid)
+	}
+}
+
+// checkSeqNoFile 检查保存事务序列号的文件，需要修复时拷贝到修复目录
+func (c *checker) checkSeqNoFile() error {
+	name := data.SeqNoFileName
+	if _, err := os.Stat(filepath.Join(c.dirPath, name)); os.IsNotExist(err) {
+		return nil
+	}
+	seqNoFile, err := data.OpenSeqNoFile(c.dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+	record, _, err := seqNoFile.ReadLogRecord(0)
+	if err != nil {
+		c.report.addError(name, 0, 0, "failed to read record: %v", err)
+		return nil
+	}
+	if _, err := strconv.ParseUint(string(record.Value), 10, 64); err != nil {
+		c.report.addError(name, 0, 0, "invalid seq number %q", record.Value)
+		return nil
+	}
+	if c.repairPath == "" {
+		return nil
+	}
+	repairFile, err := data.OpenSeqNoFile(c.repairPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = repairFile.Close()
+	}()
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := repairFile.Write(encRecord); err != nil {
+		return err
+	}
+	return repairFile.Sync()
+}
+
+// checkMergeDir 检查是否存在 merge 留下的目录
+func (c *checker) checkMergeDir() {
+	mergePath := filepath.Clean(c.dirPath) + mergeDirName
+	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
+		return
+	}
+	name := filepath.Base(mergePath)
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err == nil {
+		c.report.addWarning(name, -1, 0, "orphan merge directory with finished merge, it will be applied on next open")
+	} else {
+		c.report.addWarning(name, -1, 0, "orphan merge directory with unfinished merge, it will be discarded on next open")
+	}
+}
+
+// scanRecords 按顺序读取文件中的所有记录
+// 读到文件结束标识时停止，遇到损坏或不完整的记录时逐字节向后查找下一条可以完整读取的记录，并通过 onBad 报告跳过的区域
+func scanRecords(dataFile *data.DataFile,
+	onRecord func(record *data.LogRecord, offset, size int64) error,
+	onBad func(offset, length int64, cause error)) error {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	var offset int64 = 0
+	for offset < fileSize {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			if err := onRecord(record, offset, size); err != nil {
+				return err
+			}
+			offset += size
+			continue
+		}
+		if err != io.EOF && err != data.ErrInvalidCRC && err != data.ErrInvalidHeader {
+			return err
+		}
+		// 文件结束标识之后都是填充，不需要检查
+		if err == io.EOF && dataFile.IsEndOfFile(offset) {
+			return nil
+		}
+
+		next := offset + 1
+		for ; next < fileSize; next++ {
+			if _, _, err := dataFile.ReadLogRecord(next); err == nil {
+				break
+			}
+		}
+		onBad(offset, next-offset, err)
+		offset = next
+	}
+	return nil
+}
+
+func describeBadRegion(cause error) string {
+	if cause == data.ErrInvalidCRC {
+		return "invalid crc"
+	}
+	if cause == data.ErrInvalidHeader {
+		return "invalid record header"
+	}
+	return "incomplete or zero-filled record"
+}
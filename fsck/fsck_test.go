@@ -0,0 +1,186 @@
+package fsck
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func prepareDB(t *testing.T) (bitcask.Options, *bitcask.DB) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+	return opts, db
+}
+
+func TestCheck(t *testing.T) {
+	opts, db := prepareDB(t)
+	defer os.RemoveAll(opts.DirPath)
+
+	// 数据库正在使用
+	_, err := Check(opts.DirPath)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, db.Close())
+
+	report, err := Check(opts.DirPath)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 0, len(report.Warnings))
+	assert.Equal(t, 1000+100+1, report.Records)
+
+	// 数据文件中间损坏，以及没有完成的事务
+	dataFile, err := os.OpenFile(data.GetDataFileName(opts.DirPath, 0), os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte("corrupted"), 1000)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+	assert.Nil(t, os.MkdirAll(opts.DirPath+mergeDirName, os.ModePerm))
+	defer os.RemoveAll(opts.DirPath + mergeDirName)
+
+	report, err = Check(opts.DirPath)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.True(t, report.BadBytes > 0)
+	assert.True(t, report.Records < 1000+100+1)
+	// 损坏的数据文件和 hint 文件不再一致
+	var hintErrors int
+	for _, issue := range report.Errors {
+		if filepath.Ext(issue.File) == data.HintFileNameSuffix {
+			hintErrors++
+		}
+	}
+	assert.NotEqual(t, 0, hintErrors)
+	assert.NotEqual(t, 0, len(report.Warnings))
+}
+
+func TestCheck_GarbageTail(t *testing.T) {
+	opts, db := prepareDB(t)
+	defer os.RemoveAll(opts.DirPath)
+	assert.Nil(t, db.Close())
+
+	// 活跃文件末尾追加了非零的垃圾数据，header 无法解码
+	names, err := filepath.Glob(filepath.Join(opts.DirPath, "*"+data.DataFileNameSuffix))
+	assert.Nil(t, err)
+	activeFile, err := os.OpenFile(names[len(names)-1], os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	_, err = activeFile.Write(bytes.Repeat([]byte{0xff}, 40))
+	assert.Nil(t, err)
+	assert.Nil(t, activeFile.Close())
+
+	report, err := Check(opts.DirPath)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, int64(40), report.BadBytes)
+	assert.Equal(t, 1000+100+1, report.Records)
+	assert.Equal(t, 1, len(report.Errors))
+	assert.Equal(t, filepath.Base(names[len(names)-1]), report.Errors[0].File)
+	assert.Contains(t, report.Errors[0].String(), "invalid record header")
+
+	// 修复之后可以正常打开
+	repairPath, _ := os.MkdirTemp("", "bitcask-go-fsck-repair")
+	defer os.RemoveAll(repairPath)
+	report, err = Repair(opts.DirPath, repairPath)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(40), report.BadBytes)
+	opts.DirPath = repairPath
+	db, err = bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestRepair(t *testing.T) {
+	opts, db := prepareDB(t)
+	defer os.RemoveAll(opts.DirPath)
+	assert.Nil(t, db.Close())
+
+	dataFile, err := os.OpenFile(data.GetDataFileName(opts.DirPath, 0), os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte("corrupted"), 30000)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	repairPath, _ := os.MkdirTemp("", "bitcask-go-fsck-repair")
+	defer os.RemoveAll(repairPath)
+	report, err := Repair(opts.DirPath, repairPath)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+
+	// 修复后的目录没有问题，可以正常打开，只丢失了损坏区域中的数据
+	report, err = Check(repairPath)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+
+	opts.DirPath = repairPath
+	repaired, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	keys := repaired.ListKeys()
+	assert.True(t, len(keys) < 900)
+	assert.True(t, len(keys) > 800)
+	for i := 0; i < 100; i++ {
+		_, err := repaired.Get(utils.GetTestKey(i))
+		assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	}
+	assert.Nil(t, repaired.Close())
+
+	// 修复目录不为空
+	_, err = Repair(opts.DirPath, repairPath)
+	assert.Equal(t, ErrRepairDirExists, err)
+}
+
+func TestCheck_Blob(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-blob")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.BlobThreshold = 256
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.Nil(t, db.Close())
+
+	report, err := Check(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.BlobFiles)
+
+	// 修复时 blob 文件原样拷贝
+	repairPath, _ := os.MkdirTemp("", "bitcask-go-fsck-blob-repair")
+	defer os.RemoveAll(repairPath)
+	_, err = Repair(dir, repairPath)
+	assert.Nil(t, err)
+	opts.DirPath = repairPath
+	repaired, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(repaired.ListKeys()))
+	assert.Nil(t, repaired.Close())
+
+	// 被引用的 blob 损坏
+	blobFile, err := os.OpenFile(data.GetBlobFileName(dir, 0), os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = blobFile.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	assert.Nil(t, blobFile.Close())
+	report, err = Check(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+}
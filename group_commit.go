@@ -0,0 +1,277 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sync"
+	"time"
+)
+
+// groupCommit 将并发的同步写入合并成一次持久化
+// 写入者持有互斥锁写完数据之后释放锁，再等待数据持久化，第一个等待的写入者成为 leader 负责持久化活跃文件
+// leader 持久化期间到达的写入者等待下一次持久化，一次持久化覆盖开始之前写入的所有数据，每个写入者返回时自己的数据都已经持久化
+type groupCommit struct {
+	mu       *sync.Mutex
+	cond     *sync.Cond
+	maxDelay time.Duration // leader 开始持久化之前最多等待的时间
+	maxBatch int           // 等待的写入者达到这个数量时 leader 不再等待
+	synced   uint64        // 已经持久化的写入序号
+	failed   uint64        // 最近一次持久化失败时覆盖的写入序号，不大于它且还没有持久化的写入者返回 err
+	err      error         // 最近一次持久化失败的错误
+	syncing  bool          // 是否已经有 leader
+	waiters  int           // 正在等待持久化的写入者数量
+	full     chan struct{} // 等待的写入者达到 maxBatch 时通知 leader
+}
+
+func newGroupCommit(maxDelay time.Duration, maxBatch int) *groupCommit {
+	mu := new(sync.Mutex)
+	return &groupCommit{
+		mu:       mu,
+		cond:     sync.NewCond(mu),
+		maxDelay: maxDelay,
+		maxBatch: maxBatch,
+		full:     make(chan struct{}, 1),
+	}
+}
+
+// unsyncedWrite 一次写操作对索引的修改，写入的数据持久化之后才会更新到索引中
+// 持久化完成之前读操作看不到这些修改，避免读到崩溃之后会丢失的数据
+type unsyncedWrite struct {
+	seq uint64             // 写操作写入的最后一条记录的写入序号
+	ops []*unsyncedIndexOp // 按照顺序对索引的修改
+	err error              // 持久化失败的错误，处理完成之后设置，成功时为空
+}
+
+// unsyncedIndexOp 等待持久化的一次索引修改
+type unsyncedIndexOp struct {
+	key []byte
+	pos *data.LogRecordPos // 为 nil 表示删除
+}
+
+// update 持有互斥锁执行写操作，sync 为 true 时在释放互斥锁之后等待写入的数据持久化
+// 同步写入对索引的修改在持久化成功之后才生效，写操作返回之前读操作看不到未持久化的数据
+// 还有等待持久化的写入时，之后的写入也要等待它们完成，保证索引按照写入的顺序更新
+// 所有的写操作都通过 update 完成，只读模式下直接返回 ErrReadOnly，数据库关闭之后返回 ErrDatabaseClosed
+func (db *DB) update(sync bool, fn func() error) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return ErrDatabaseClosed
+	}
+	prevSeq := db.writeSeq
+	var write *unsyncedWrite
+	if sync || len(db.unsyncedWrites) > 0 {
+		write = &unsyncedWrite{}
+		db.deferredWrite = write
+	}
+	err := fn()
+	db.deferredWrite = nil
+	writeSeq := db.writeSeq
+	if write != nil && len(write.ops) > 0 {
+		write.seq = writeSeq
+		db.unsyncedWrites = append(db.unsyncedWrites, write)
+	} else {
+		write = nil
+	}
+	db.mu.Unlock()
+	if writeSeq == prevSeq || (write == nil && (err != nil || !sync)) {
+		return err
+	}
+	syncErr := db.groupCommit.wait(db, writeSeq)
+	// 索引的修改由第一次覆盖了这次写入的持久化处理，以处理的结果为准
+	if write != nil {
+		syncErr = write.err
+	}
+	if err != nil {
+		return err
+	}
+	return syncErr
+}
+
+// putIndex 更新 key 的索引，update 中需要等待持久化的写入先暂存修改，需要持有互斥锁
+func (db *DB) putIndex(key []byte, pos *data.LogRecordPos) {
+	if db.deferredWrite != nil {
+		db.deferIndexOp(key, pos)
+		return
+	}
+	if oldPos := db.index.Put(key, pos); oldPos != nil {
+		db.markReclaimable(oldPos)
+	}
+}
+
+// deleteIndex 删除 key 的索引，update 中需要等待持久化的写入先暂存修改，需要持有互斥锁
+func (db *DB) deleteIndex(key []byte) bool {
+	if db.deferredWrite != nil {
+		db.deferIndexOp(key, nil)
+		return true
+	}
+	oldPos, ok := db.index.Delete(key)
+	if oldPos != nil {
+		db.markReclaimable(oldPos)
+	}
+	return ok
+}
+
+func (db *DB) deferIndexOp(key []byte, pos *data.LogRecordPos) {
+	op := &unsyncedIndexOp{key: key, pos: pos}
+	db.deferredWrite.ops = append(db.deferredWrite.ops, op)
+	db.unsyncedIndex[string(key)] = op
+}
+
+// latestPos 返回 key 最近一次写入的位置，包括还在等待持久化的写入，需要持有互斥锁
+// 写操作根据 key 的当前状态决定如何写入时使用，读操作只能看到已经更新到索引中的数据
+func (db *DB) latestPos(key []byte) *data.LogRecordPos {
+	if op, ok := db.unsyncedIndex[string(key)]; ok {
+		return op.pos
+	}
+	return db.index.Get(key)
+}
+
+// settleUnsyncedWrites 处理写入序号不大于 seq 的等待持久化的写入，需要持有互斥锁
+// 持久化成功时将修改更新到索引中，失败时丢弃修改，写入的数据作为无效数据
+func (db *DB) settleUnsyncedWrites(seq uint64, err error) {
+	n := 0
+	for _, write := range db.unsyncedWrites {
+		if write.seq > seq {
+			break
+		}
+		write.err = err
+		for _, op := range write.ops {
+			if db.unsyncedIndex[string(op.key)] == op {
+				delete(db.unsyncedIndex, string(op.key))
+			}
+			switch {
+			case err != nil && op.pos != nil:
+				db.markReclaimable(op.pos)
+			case err != nil:
+				// 删除标记在写入时已经计入了无效数据
+			case op.pos != nil:
+				if oldPos := db.index.Put(op.key, op.pos); oldPos != nil {
+					db.markReclaimable(oldPos)
+				}
+			default:
+				if oldPos, _ := db.index.Delete(op.key); oldPos != nil {
+					db.markReclaimable(oldPos)
+				}
+			}
+		}
+		n++
+	}
+	if n > 0 {
+		db.unsyncedWrites = append(db.unsyncedWrites[:0], db.unsyncedWrites[n:]...)
+	}
+}
+
+// wait 等待写入序号不大于 writeSeq 的数据持久化，没有 leader 时自己成为 leader
+func (gc *groupCommit) wait(db *DB, writeSeq uint64) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.waiters++
+	defer func() {
+		gc.waiters--
+	}()
+	if gc.maxBatch > 0 && gc.waiters >= gc.maxBatch {
+		select {
+		case gc.full <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		if gc.synced >= writeSeq {
+			return nil
+		}
+		// 覆盖了这次写入的持久化失败了，不能再重试，重试成功也不能保证之前的数据已经写入磁盘
+		if gc.failed >= writeSeq {
+			return gc.err
+		}
+		if !gc.syncing {
+			break
+		}
+		gc.cond.Wait()
+	}
+
+	gc.syncing = true
+	gc.mu.Unlock()
+	gc.collect()
+	syncedSeq, err := db.syncWrites()
+	// 持久化覆盖的写入更新索引之后，等待的写入者才能返回
+	db.mu.Lock()
+	if !db.closed.Load() {
+		db.settleUnsyncedWrites(syncedSeq, err)
+	}
+	db.mu.Unlock()
+	gc.mu.Lock()
+	gc.syncing = false
+	if err != nil {
+		gc.failed, gc.err = syncedSeq, err
+	} else if syncedSeq > gc.synced {
+		gc.synced = syncedSeq
+	}
+	gc.cond.Broadcast()
+	return err
+}
+
+// collect leader 持久化之前等待更多的写入者，最多等待 maxDelay，等待的写入者达到 maxBatch 时提前结束
+func (gc *groupCommit) collect() {
+	if gc.maxDelay <= 0 {
+		return
+	}
+	gc.mu.Lock()
+	// 丢弃之前的通知，重新判断等待的写入者数量
+	select {
+	case <-gc.full:
+	default:
+	}
+	full := gc.maxBatch > 0 && gc.waiters >= gc.maxBatch
+	gc.mu.Unlock()
+	if full {
+		return
+	}
+
+	timer := time.NewTimer(gc.maxDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-gc.full:
+	}
+}
+
+// syncWrites 持久化活跃 blob 文件和活跃数据文件，返回持久化覆盖的写入序号
+// 持久化期间不持有互斥锁，其他写入者可以继续写入，引用要持久化的文件，避免文件被 merge 删除后关闭
+// 之前的活跃文件在转换成旧文件时已经持久化过了
+func (db *DB) syncWrites() (uint64, error) {
+	db.mu.Lock()
+	writeSeq := db.writeSeq
+	files := &fileSet{
+		dataFiles: make(map[uint32]*data.DataFile, 1),
+		blobFiles: make(map[uint32]*data.DataFile, 1),
+	}
+	if db.activeFile != nil && db.activeFile.Acquire() {
+		files.dataFiles[db.activeFile.FileId] = db.activeFile
+	}
+	if db.activeBlobFile != nil && db.blobUnsynced && db.activeBlobFile.Acquire() {
+		files.blobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		db.blobUnsynced = false
+	}
+	db.mu.Unlock()
+	defer db.unpinDataFiles(files)
+
+	// 引用 blob 的记录持久化之前，blob 需要先持久化
+	for _, blobFile := range files.blobFiles {
+		if err := db.syncFile(blobFile, true); err != nil {
+			db.mu.Lock()
+			db.blobUnsynced = true
+			db.mu.Unlock()
+			return writeSeq, err
+		}
+	}
+	for _, dataFile := range files.dataFiles {
+		if err := db.syncFile(dataFile, false); err != nil {
+			return writeSeq, err
+		}
+	}
+	return writeSeq, nil
+}
@@ -0,0 +1,152 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发的同步写入，包括单条写入、批量写入和事务
+	value := utils.RandomValue(128)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := utils.GetTestKey(i*100 + j)
+				switch j % 3 {
+				case 0:
+					assert.Nil(t, db.Put(key, value))
+				case 1:
+					wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+					assert.Nil(t, wb.Put(key, value))
+					assert.Nil(t, wb.Commit())
+				case 2:
+					txn, err := db.Begin(DefaultTxnOptions)
+					assert.Nil(t, err)
+					assert.Nil(t, txn.Put(key, value))
+					assert.Nil(t, txn.Commit())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 所有写入都已经持久化
+	assert.Equal(t, db.writeSeq, db.groupCommit.synced)
+	assert.False(t, db.groupCommit.syncing)
+	assert.Equal(t, 0, db.groupCommit.waiters)
+	assert.Equal(t, 0, pinnedFileCount(db))
+
+	// 没有写入数据的操作不需要等待持久化
+	assert.Nil(t, db.Delete([]byte("not exist")))
+	assert.Equal(t, db.writeSeq, db.groupCommit.synced)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	destroyDB(db)
+}
+
+func TestDB_GroupCommit_Delay(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-delay")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommitMaxDelay = 100 * time.Millisecond
+	opts.GroupCommitMaxBatch = 4
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// 单独的写入者等待 GroupCommitMaxDelay 之后持久化
+	start := time.Now()
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	assert.True(t, time.Since(start) >= opts.GroupCommitMaxDelay)
+
+	// 等待的写入者达到 GroupCommitMaxBatch 时立即持久化
+	db.groupCommit.maxDelay = time.Minute
+	start = time.Now()
+	value := utils.RandomValue(128)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.GroupCommitMaxBatch; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, time.Since(start) < time.Minute/2)
+	assert.Equal(t, db.writeSeq, db.groupCommit.synced)
+}
+
+// 同步写入在持久化之前对读操作不可见，条件写入以等待持久化的写入为准
+func TestDB_GroupCommit_Visibility(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-visibility")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.GroupCommitMaxDelay = 200 * time.Millisecond
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+
+	key := utils.GetTestKey(0)
+	value := utils.RandomValue(128)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, db.Put(key, value))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(db.ListKeys()))
+
+	newValue := utils.RandomValue(128)
+	swapped, err := db.CompareAndSwap(key, value, newValue)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	<-done
+	val, err := db.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, newValue, val)
+	assert.Equal(t, 0, len(db.unsyncedWrites))
+	assert.Equal(t, 0, len(db.unsyncedIndex))
+
+	// 删除等待持久化的写入
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, db.Put(key, value))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, db.Delete(key))
+	<-done
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,78 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"io"
+	"os"
+)
+
+// appendHintRecord 记录活跃文件中新写入数据的位置，需要持有互斥锁
+func (db *DB) appendHintRecord(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) error {
+	// B+ 树索引不需要从数据文件中加载索引
+	if db.options.IndexerType == BPlusTreeIndex {
+		return nil
+	}
+	encRecord, err := db.codec.EncodeHintRecord(key, typ, pos)
+	if err != nil {
+		return err
+	}
+	db.activeHints = append(db.activeHints, encRecord...)
+	return nil
+}
+
+// writeActiveHintFile 活跃文件转换成旧文件之前，为其写入对应的 hint 文件，需要持有互斥锁
+func (db *DB) writeActiveHintFile() error {
+	if db.options.IndexerType == BPlusTreeIndex {
+		return nil
+	}
+	if err := writeHintFile(db.options.DirPath, db.activeFile.FileId, db.activeHints); err != nil {
+		return err
+	}
+	db.activeHints = nil
+	return nil
+}
+
+// writeHintFile 将编码后的 hint 记录写入数据文件对应的 hint 文件
+func writeHintFile(dirPath string, fileId uint32, hints []byte) error {
+	return utils.WriteFileAtomic(data.GetHintFileName(dirPath, fileId), hints, fio.DataFilePerm)
+}
+
+// readHintFile 读取数据文件对应的 hint 文件中的全部记录，hint 文件不存在时 ok 为 false
+func readHintFile(dirPath string, fileId uint32, codec *data.Codec) (records []*data.LogRecord, ok bool, err error) {
+	if _, err := os.Stat(data.GetHintFileName(dirPath, fileId)); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	hintFile, err := data.OpenDataHintFile(dirPath, fileId)
+	if err != nil {
+		return nil, false, err
+	}
+	hintFile.Codec = codec
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	var offset int64 = 0
+	for {
+		record, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, false, err
+		}
+		records = append(records, record)
+		offset += size
+	}
+	return records, true, nil
+}
+
+// removeHintFile 删除数据文件对应的 hint 文件
+func removeHintFile(dirPath string, fileId uint32) error {
+	err := os.Remove(data.GetHintFileName(dirPath, fileId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
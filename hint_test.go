@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_HintFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 批量写入的数据可能跨越多个文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 2000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 每个旧数据文件都有对应的 hint 文件，活跃文件没有
+	for fid := range db.olderFiles {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+	_, err = os.Stat(data.GetHintFileName(dir, db.activeFile.FileId))
+	assert.True(t, os.IsNotExist(err))
+	reclaimSize := db.reclaimSize
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 旧数据文件损坏也不影响索引的加载，说明索引是从 hint 文件中加载的
+	dataFile, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = dataFile.WriteAt([]byte("corrupted"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1500, len(db2.ListKeys()))
+	assert.Equal(t, reclaimSize, db2.reclaimSize)
+	for i := 1000; i < 2000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db2.Close())
+
+	// hint 文件损坏时退回到读取数据文件，并重新生成 hint 文件
+	hintFile, err := os.OpenFile(data.GetHintFileName(dir, 1), os.O_WRONLY, 0)
+	assert.Nil(t, err)
+	_, err = hintFile.WriteAt([]byte("corrupted"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, hintFile.Close())
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 2000; i++ {
+		_, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	records, ok, err := readHintFile(dir, 1, nil)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.NotEqual(t, 0, len(records))
+	assert.Nil(t, db3.Close())
+}
@@ -16,6 +16,7 @@ func init() {
   options := bitcask.DefaultOptions
   dir, _ := os.MkdirTemp("", "bitcask-go-http")
   options.DirPath = dir
+  options.Logger = bitcask.NewStdLogger(log.Default(), bitcask.LogLevelInfo)
   db, err = bitcask.Open(options)
   if err != nil {
     panic(fmt.Sprintf("failed to open db: %v", err))
@@ -98,7 +99,11 @@ func handleStat(writer http.ResponseWriter, request *http.Request) {
     return
   }
 
-  stat := db.Stat()
+  stat, err := db.Stat()
+  if err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
   writer.Header().Set("Content-Type", "application/json")
   _ = json.NewEncoder(writer).Encode(stat)
 }
@@ -110,6 +115,7 @@ func main() {
   http.HandleFunc("/bitcask/delete", handleDelete)
   http.HandleFunc("/bitcask/listkeys", handleListKeys)
   http.HandleFunc("/bitcask/stat", handleStat)
+  http.Handle("/metrics", bitcask.MetricsHandler(db))
 
   // 启动 HTTP 服务
   http.ListenAndServe("localhost:8080", nil)
@@ -66,6 +66,21 @@ func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	return newARTIterator(art.tree, reverse)
 }
 
+// Clone 遍历所有节点，构造一棵新的自适应基数树
+func (art *AdaptiveRadixTree) Clone() (Indexer, error) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	tree := goart.New()
+	art.tree.ForEach(func(node goart.Node) bool {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaptiveRadixTree{
+		tree: tree,
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
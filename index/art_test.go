@@ -76,3 +76,18 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Clone(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 12})
+
+	clone, err := art.Clone()
+	assert.Nil(t, err)
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 12})
+	art.Delete([]byte("key-2"))
+
+	assert.Equal(t, 2, clone.Size())
+	assert.Equal(t, uint32(1), clone.Get([]byte("key-1")).Fid)
+	assert.NotNil(t, clone.Get([]byte("key-2")))
+}
@@ -2,8 +2,12 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
 	"go.etcd.io/bbolt"
 	"path/filepath"
+	"sort"
+	"sync"
 )
 
 const bptreeIndexFileName = "bptree-index"
@@ -13,16 +17,18 @@ var indexBucketName = []byte("bitcask-index")
 // BPlusTree B+ 树索引
 // 主要封装了 go.etcd.io/bbolt 库
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree      *bbolt.DB
+	mu        sync.RWMutex                 // 写入期间持有写锁，快照读取时持有读锁，保证快照保存的旧位置和 B+ 树一致
+	snapshots map[*bptreeSnapshot]struct{} // 还没有关闭的快照
 }
 
-// NewBPlusTree 初始化 B+ 树索引
-func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
+// NewBPlusTree 初始化 B+ 树索引，索引文件打开失败时返回错误
+func NewBPlusTree(dirPath string, syncWrites bool) (*BPlusTree, error) {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
 	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
 	if err != nil {
-		panic("failed to open bptree")
+		return nil, fmt.Errorf("failed to open bptree: %w", err)
 	}
 
 	// 创建对应的 bucket
@@ -30,17 +36,21 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 		_, err := tx.CreateBucketIfNotExists(indexBucketName)
 		return err
 	}); err != nil {
-		panic("failed to create bucket in bptree")
+		_ = bptree.Close()
+		return nil, fmt.Errorf("failed to create bucket in bptree: %w", err)
 	}
 
-	return &BPlusTree{tree: bptree}
+	return &BPlusTree{tree: bptree, snapshots: make(map[*bptreeSnapshot]struct{})}, nil
 }
 
 func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	bpt.mu.Lock()
+	defer bpt.mu.Unlock()
 	var oldValue []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		oldValue = bucket.Get(key)
+		bpt.saveToSnapshots(key, oldValue)
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
 		panic("failed to put value in bptree")
@@ -67,10 +77,13 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 }
 
 func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+	bpt.mu.Lock()
+	defer bpt.mu.Unlock()
 	var oldVal []byte
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
 		if oldVal = bucket.Get(key); len(oldVal) != 0 {
+			bpt.saveToSnapshots(key, oldVal)
 			return bucket.Delete(key)
 		}
 		return nil
@@ -99,6 +112,42 @@ func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
 
+// Clone 返回 B+ 树在当前时刻的快照，不拷贝索引
+// 快照创建之后被修改的 key 在修改之前将旧的位置保存到快照中，其余的 key 直接从 B+ 树中读取
+// 长时间持有 bbolt 的读事务会阻塞写事务的 remap，因此快照不持有读事务
+func (bpt *BPlusTree) Clone() (Indexer, error) {
+	bpt.mu.Lock()
+	defer bpt.mu.Unlock()
+	var size int
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		size = tx.Bucket(indexBucketName).Stats().KeyN
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to clone bptree: %w", err)
+	}
+	snapshot := &bptreeSnapshot{
+		bpt:   bpt,
+		saved: make(map[string]*data.LogRecordPos),
+		size:  size,
+	}
+	bpt.snapshots[snapshot] = struct{}{}
+	return snapshot, nil
+}
+
+// saveToSnapshots 在修改 key 之前将旧的位置保存到还没有保存过这个 key 的快照中，需要持有写锁
+func (bpt *BPlusTree) saveToSnapshots(key []byte, oldValue []byte) {
+	for snapshot := range bpt.snapshots {
+		if _, ok := snapshot.saved[string(key)]; ok {
+			continue
+		}
+		var oldPos *data.LogRecordPos
+		if len(oldValue) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldValue)
+		}
+		snapshot.saved[string(key)] = oldPos
+	}
+}
+
 func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
@@ -164,3 +213,202 @@ func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 func (bpi *bptreeIterator) Close() {
 	_ = bpi.tx.Rollback()
 }
+
+// bptreeSnapshot B+ 树在某一时刻的快照
+// 占用的内存和快照创建之后修改过的 key 的数量成正比，不再使用时需要调用 Close
+type bptreeSnapshot struct {
+	bpt   *BPlusTree
+	saved map[string]*data.LogRecordPos // 快照创建之后被修改过的 key 在快照中的位置，为 nil 表示不存在
+	size  int
+}
+
+func (s *bptreeSnapshot) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	s.bpt.mu.Lock()
+	defer s.bpt.mu.Unlock()
+	oldPos := s.get(key)
+	if oldPos == nil {
+		s.size++
+	}
+	s.saved[string(key)] = pos
+	return oldPos
+}
+
+func (s *bptreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	s.bpt.mu.RLock()
+	defer s.bpt.mu.RUnlock()
+	return s.get(key)
+}
+
+// get 先查找快照中保存的位置，没有被修改过的 key 从 B+ 树中读取，需要持有锁
+func (s *bptreeSnapshot) get(key []byte) *data.LogRecordPos {
+	if pos, ok := s.saved[string(key)]; ok {
+		return pos
+	}
+	return s.bpt.Get(key)
+}
+
+func (s *bptreeSnapshot) Delete(key []byte) (*data.LogRecordPos, bool) {
+	s.bpt.mu.Lock()
+	defer s.bpt.mu.Unlock()
+	oldPos := s.get(key)
+	if oldPos == nil {
+		return nil, false
+	}
+	s.size--
+	s.saved[string(key)] = nil
+	return oldPos, true
+}
+
+func (s *bptreeSnapshot) Size() int {
+	s.bpt.mu.RLock()
+	defer s.bpt.mu.RUnlock()
+	return s.size
+}
+
+// Iterator 合并 B+ 树的迭代器和快照中保存的位置
+func (s *bptreeSnapshot) Iterator(reverse bool) Iterator {
+	s.bpt.mu.RLock()
+	defer s.bpt.mu.RUnlock()
+	items := make([]*Item, 0, len(s.saved))
+	for key, pos := range s.saved {
+		items = append(items, &Item{key: []byte(key), pos: pos})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(items[i].key, items[j].key) > 0
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	it := &bptreeSnapshotIterator{
+		live:    newBptreeIterator(s.bpt.tree, reverse),
+		items:   items,
+		reverse: reverse,
+	}
+	it.settle()
+	return it
+}
+
+// Clone 快照的拷贝是同一时刻的另一个快照
+func (s *bptreeSnapshot) Clone() (Indexer, error) {
+	s.bpt.mu.Lock()
+	defer s.bpt.mu.Unlock()
+	clone := &bptreeSnapshot{
+		bpt:   s.bpt,
+		saved: make(map[string]*data.LogRecordPos, len(s.saved)),
+		size:  s.size,
+	}
+	for key, pos := range s.saved {
+		clone.saved[key] = pos
+	}
+	s.bpt.snapshots[clone] = struct{}{}
+	return clone, nil
+}
+
+func (s *bptreeSnapshot) Close() error {
+	s.bpt.mu.Lock()
+	defer s.bpt.mu.Unlock()
+	delete(s.bpt.snapshots, s)
+	s.saved = nil
+	return nil
+}
+
+// bptreeSnapshotIterator 快照的迭代器，被修改过的 key 以快照中保存的位置为准
+type bptreeSnapshotIterator struct {
+	live      *bptreeIterator
+	items     []*Item // 快照中保存的 key 和位置，按照遍历的顺序排列
+	idx       int
+	reverse   bool
+	fromLive  bool // 当前的 key 来自 B+ 树
+	fromSaved bool // 当前的 key 来自快照中保存的位置
+}
+
+// settle 从 B+ 树和快照中保存的 key 中选出下一个在快照中存在的 key
+func (it *bptreeSnapshotIterator) settle() {
+	for {
+		liveValid, savedValid := it.live.Valid(), it.idx < len(it.items)
+		it.fromLive, it.fromSaved = false, false
+		if !liveValid && !savedValid {
+			return
+		}
+		cmp := 1
+		if liveValid && savedValid {
+			cmp = bytes.Compare(it.live.Key(), it.items[it.idx].key)
+			if it.reverse {
+				cmp = -cmp
+			}
+		} else if liveValid {
+			cmp = -1
+		}
+		if cmp < 0 {
+			it.fromLive = true
+			return
+		}
+		// 被修改过的 key 跳过 B+ 树中的位置
+		if cmp == 0 {
+			it.live.Next()
+		}
+		if it.items[it.idx].pos != nil {
+			it.fromSaved = true
+			return
+		}
+		it.idx++
+	}
+}
+
+func (it *bptreeSnapshotIterator) Rewind() {
+	it.live.Rewind()
+	it.idx = 0
+	it.settle()
+}
+
+func (it *bptreeSnapshotIterator) Seek(key []byte) {
+	it.live.Seek(key)
+	if it.reverse {
+		// 游标定位到第一个不小于 key 的位置，反向遍历从最后一个不大于 key 的位置开始
+		if !it.live.Valid() {
+			it.live.curKey, it.live.curValue = it.live.cursor.Last()
+		} else if bytes.Compare(it.live.Key(), key) > 0 {
+			it.live.curKey, it.live.curValue = it.live.cursor.Prev()
+		}
+		it.idx = sort.Search(len(it.items), func(i int) bool {
+			return bytes.Compare(it.items[i].key, key) <= 0
+		})
+	} else {
+		it.idx = sort.Search(len(it.items), func(i int) bool {
+			return bytes.Compare(it.items[i].key, key) >= 0
+		})
+	}
+	it.settle()
+}
+
+func (it *bptreeSnapshotIterator) Next() {
+	if it.fromLive {
+		it.live.Next()
+	} else if it.fromSaved {
+		it.idx++
+	}
+	it.settle()
+}
+
+func (it *bptreeSnapshotIterator) Valid() bool {
+	return it.fromLive || it.fromSaved
+}
+
+func (it *bptreeSnapshotIterator) Key() []byte {
+	if it.fromLive {
+		return it.live.Key()
+	}
+	return it.items[it.idx].key
+}
+
+func (it *bptreeSnapshotIterator) Value() *data.LogRecordPos {
+	if it.fromLive {
+		return it.live.Value()
+	}
+	return it.items[it.idx].pos
+}
+
+func (it *bptreeSnapshotIterator) Close() {
+	it.live.Close()
+	it.items = nil
+}
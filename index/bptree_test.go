@@ -14,7 +14,8 @@ func TestBPlusTree_Put(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	res1 := tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	assert.Nil(t, res1)
@@ -34,7 +35,8 @@ func TestBPlusTree_Get(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	pos := tree.Get([]byte("not exist"))
 	assert.Nil(t, pos)
@@ -54,7 +56,8 @@ func TestBPlusTree_Delete(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	res1, ok1 := tree.Delete([]byte("not exist"))
 	assert.False(t, ok1)
@@ -76,7 +79,8 @@ func TestBPlusTree_Size(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	assert.Equal(t, 0, tree.Size())
 
@@ -93,7 +97,8 @@ func TestBPlusTree_Iterator(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
 
 	tree.Put([]byte("caac"), &data.LogRecordPos{Fid: 123, Offset: 999})
 	tree.Put([]byte("bbca"), &data.LogRecordPos{Fid: 123, Offset: 999})
@@ -107,3 +112,89 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestNewBPlusTree_OpenFailed(t *testing.T) {
+	// 目录不存在时无法创建索引文件，返回错误而不是 panic
+	path := filepath.Join(os.TempDir(), "bptree-not-exist", "sub")
+	tree, err := NewBPlusTree(path, false)
+	assert.NotNil(t, err)
+	assert.Nil(t, tree)
+
+	_, err = NewIndexer(BPTree, path, false)
+	assert.NotNil(t, err)
+	_, err = NewIndexer(IndexerType(100), path, false)
+	assert.NotNil(t, err)
+}
+
+func TestBPlusTree_Clone(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-clone")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := NewBPlusTree(path, false)
+	assert.Nil(t, err)
+	defer func() {
+		_ = tree.Close()
+	}()
+
+	for _, key := range []string{"a", "b", "c", "e"} {
+		tree.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+	clone, err := tree.Clone()
+	assert.Nil(t, err)
+
+	// 修改原索引，拷贝不受影响
+	tree.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	tree.Put([]byte("a"), &data.LogRecordPos{Fid: 3, Offset: 30})
+	tree.Delete([]byte("c"))
+	tree.Put([]byte("d"), &data.LogRecordPos{Fid: 2, Offset: 40})
+	tree.Put([]byte("f"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, 4, clone.Size())
+	assert.Equal(t, uint32(1), clone.Get([]byte("a")).Fid)
+	assert.NotNil(t, clone.Get([]byte("c")))
+	assert.Nil(t, clone.Get([]byte("d")))
+	assert.Equal(t, uint32(3), tree.Get([]byte("a")).Fid)
+	assert.Equal(t, 5, tree.Size())
+
+	// 迭代器合并 B+ 树和保存的旧位置
+	keys := func(iter Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+			assert.Equal(t, uint32(1), iter.Value().Fid)
+		}
+		iter.Close()
+		return keys
+	}
+	iter := clone.Iterator(false)
+	iter.Rewind()
+	assert.Equal(t, []string{"a", "b", "c", "e"}, keys(iter))
+	iter = clone.Iterator(true)
+	iter.Rewind()
+	assert.Equal(t, []string{"e", "c", "b", "a"}, keys(iter))
+	iter = clone.Iterator(false)
+	iter.Seek([]byte("bb"))
+	assert.Equal(t, []string{"c", "e"}, keys(iter))
+	iter = clone.Iterator(true)
+	iter.Seek([]byte("d"))
+	assert.Equal(t, []string{"c", "b", "a"}, keys(iter))
+	iter = clone.Iterator(true)
+	iter.Seek([]byte("z"))
+	assert.Equal(t, []string{"e", "c", "b", "a"}, keys(iter))
+
+	// 修改拷贝不影响原索引
+	clone.Put([]byte("g"), &data.LogRecordPos{Fid: 1, Offset: 50})
+	_, ok := clone.Delete([]byte("b"))
+	assert.True(t, ok)
+	assert.Equal(t, 4, clone.Size())
+	assert.Nil(t, clone.Get([]byte("b")))
+	assert.NotNil(t, tree.Get([]byte("b")))
+	assert.Nil(t, tree.Get([]byte("g")))
+
+	// 关闭之后不再保存旧位置
+	assert.Nil(t, clone.Close())
+	tree.Put([]byte("b"), &data.LogRecordPos{Fid: 2, Offset: 60})
+	assert.Equal(t, 0, len(tree.snapshots))
+}
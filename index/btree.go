@@ -10,7 +10,7 @@ import (
 
 // BTree 索引数据结构，调用 google 的轮子
 // https://github.com/google/btree
-// 写操作不是并发安全的，读操作并发安全
+// 读写操作都是并发安全的
 type BTree struct {
 	tree *btree.BTree
 	lock *sync.RWMutex
@@ -38,6 +38,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	// merge 会在不持有数据库锁的情况下读取索引，因此读操作也需要加锁
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	btreeItem := bt.tree.Get(it)
 	if btreeItem == nil {
 		return nil
@@ -57,9 +60,21 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
+// Clone BTree 的拷贝是写时复制的，开销很小
+func (bt *BTree) Clone() (Indexer, error) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
 func (bt *BTree) Close() error {
 	return nil
 }
@@ -99,3 +99,21 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Clone(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	clone, err := bt.Clone()
+	assert.Nil(t, err)
+	// 修改原索引，拷贝不受影响
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("b"))
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, 2, clone.Size())
+	assert.Equal(t, uint32(1), clone.Get([]byte("a")).Fid)
+	assert.NotNil(t, clone.Get([]byte("b")))
+	assert.Nil(t, clone.Get([]byte("c")))
+}
@@ -3,6 +3,7 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"fmt"
 	"github.com/google/btree"
 )
 
@@ -23,6 +24,9 @@ type Indexer interface {
 	// Size 返回索引中存在多少条数据
 	Size() int
 
+	// Clone 返回索引在当前时刻的一份拷贝，之后对原索引的修改不会影响到拷贝，拷贝不再使用时需要调用 Close
+	Clone() (Indexer, error)
+
 	// Close 关闭索引
 	Close() error
 }
@@ -41,16 +45,20 @@ const (
 )
 
 // NewIndexer 初始化Indexer
-func NewIndexer(typ IndexerType, dirPath string, sync bool) Indexer {
+func NewIndexer(typ IndexerType, dirPath string, sync bool) (Indexer, error) {
 	switch typ {
 	case BTreeIndex:
-		return NewBTree()
+		return NewBTree(), nil
 	case ARTIndex:
-		return NewART()
+		return NewART(), nil
 	case BPTree:
-		return NewBPlusTree(dirPath, sync)
+		bpt, err := NewBPlusTree(dirPath, sync)
+		if err != nil {
+			return nil, err
+		}
+		return bpt, nil
 	default:
-		panic("unsupported index type")
+		return nil, fmt.Errorf("unsupported index type %d", typ)
 	}
 }
 
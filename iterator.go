@@ -3,6 +3,7 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"time"
 )
 
 // Iterator 面向用户使用的迭代器
@@ -10,15 +11,35 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	files     *fileSet // 迭代器读取的数据文件和 blob 文件
+	pinned    bool     // 数据文件是否由迭代器自己引用，关闭时释放
 }
 
 // NewIterator 初始化迭代器
+// 迭代器会引用当前的数据文件，保证在迭代器关闭之前这些文件不会被 merge 删除
+// 数据库关闭之后返回的迭代器中没有数据
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
+	db.mu.Lock()
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return &Iterator{
+			indexIter: index.NewBTree().Iterator(opts.Reverse),
+			db:        db,
+			options:   opts,
+			files:     &fileSet{},
+		}
+	}
+	// 和引用数据文件在同一次加锁中创建索引迭代器，保证索引中的位置都在引用的文件中
+	files := db.pinDataFiles()
 	indexIter := db.index.Iterator(opts.Reverse)
+	db.mu.Unlock()
+
 	return &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		options:   opts,
+		files:     files,
+		pinned:    true,
 	}
 }
 
@@ -55,24 +76,32 @@ func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(logRecordPos)
+	if it.db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	return getPinnedValue(it.files, logRecordPos)
 }
 
 // Close 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.pinned {
+		it.db.unpinDataFiles(it.files)
+		it.pinned = false
+	}
 }
 
-// 过滤
+// 过滤，跳过前缀不匹配以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if isExpired(it.indexIter.Value(), now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if prefixLen == 0 || prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
 			break
 		}
 	}
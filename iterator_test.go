@@ -96,3 +96,65 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_Iterator_PinDataFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-pin")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(10), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, int64(1), db.activeFile.Refs())
+	iterator.Close()
+	assert.Equal(t, 0, pinnedFileCount(db))
+	// 重复关闭不会重复释放
+	iterator.Close()
+	assert.Equal(t, 0, pinnedFileCount(db))
+}
+
+func TestDB_Iterator_ConcurrentMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 转换活跃文件和 merge 替换数据文件的同时创建迭代器，读到的都是 key 对应的数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 5; round++ {
+			for i := 0; i < 1000; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			}
+			assert.Nil(t, db.Merge())
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		iter := db.NewIterator(DefaultIteratorOptions)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, iter.Key(), value)
+		}
+		iter.Close()
+	}
+}
@@ -0,0 +1,152 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+)
+
+// indexRecord 加载索引时从数据文件或 hint 文件中解析出的一条记录
+type indexRecord struct {
+	key []byte // 原记录的 key，包括事务序列号
+	typ data.LogRecordType
+	pos *data.LogRecordPos
+}
+
+// fileIndexRecords 一个数据文件中解析出的全部记录，按照记录在文件中的顺序排列
+type fileIndexRecords struct {
+	fileId  uint32
+	records []*indexRecord
+	offset  int64 // 最后一条完整记录的结束位置，用于维护活跃文件的写偏移
+	tailErr error // 读取 offset 之后的数据时遇到的错误
+	sealed  bool  // offset 处是文件结束标识
+	err     error
+}
+
+// loadFileIndexRecords 并行解析数据文件中的记录，并按照文件 id 从小到大的顺序依次交给 apply 处理
+// 已经解析但还没有处理完的文件也占用名额，同时在内存中的文件数量不超过 LoadConcurrency
+// 只读模式下 startFileId 对应的文件从 startOffset 开始解析，之前的记录已经加载过了
+func (db *DB) loadFileIndexRecords(fileIds []uint32, startFileId uint32, startOffset int64,
+	apply func(*fileIndexRecords) error) error {
+	concurrency := db.options.LoadConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]chan *fileIndexRecords, len(fileIds))
+	for i := range results {
+		results[i] = make(chan *fileIndexRecords, 1)
+	}
+	slots := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i, fid := range fileIds {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			var offset int64
+			if fid == startFileId && db.options.ReadOnly {
+				offset = startOffset
+			}
+			go func(i int, fid uint32, offset int64) {
+				results[i] <- db.parseFileIndexRecords(fid, offset)
+			}(i, fid, offset)
+		}
+	}()
+
+	for i := range fileIds {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		if err := apply(result); err != nil {
+			return err
+		}
+		<-slots
+	}
+	return nil
+}
+
+// parseFileIndexRecords 解析一个数据文件中从 startOffset 开始的全部记录
+// 旧数据文件优先从对应的 hint 文件中读取，hint 文件不存在或损坏时退回到读取数据文件，并补上 hint 文件
+// startOffset 不为 0 时只读取数据文件，也不写入 hint 文件
+func (db *DB) parseFileIndexRecords(fileId uint32, startOffset int64) *fileIndexRecords {
+	result := &fileIndexRecords{fileId: fileId}
+	isActiveFile := fileId == db.activeFile.FileId
+	writeHints := !isActiveFile && startOffset == 0 && !db.options.ReadOnly
+
+	if !isActiveFile && startOffset == 0 {
+		hintRecords, ok, err := readHintFile(db.options.DirPath, fileId, db.codec)
+		if err == nil && ok {
+			for _, record := range hintRecords {
+				result.records = append(result.records, &indexRecord{
+					key: record.Key,
+					typ: record.Type,
+					pos: data.DecodeLogRecordPos(record.Value),
+				})
+			}
+			return result
+		}
+	}
+
+	var dataFile *data.DataFile
+	if isActiveFile {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[fileId]
+	}
+
+	// 循环处理文件中的所有记录
+	var offset = startOffset
+	var hints []byte
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				result.sealed = dataFile.IsEndOfFile(offset)
+				break
+			}
+			// 活跃文件尾部的数据可能在崩溃时只写了一部分，交给 recoverActiveFile 处理
+			// RecoverySkip 模式下旧文件中也可能保留着损坏的数据，只加载之前完整的记录
+			if isActiveFile || db.options.RecoveryMode == RecoverySkip {
+				result.tailErr = err
+				break
+			}
+			result.err = err
+			return result
+		}
+		// 构造内存索引
+		logRecordPos := &data.LogRecordPos{
+			Fid:    fileId,
+			Offset: offset,
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		result.records = append(result.records, &indexRecord{
+			key: logRecord.Key,
+			typ: logRecord.Type,
+			pos: logRecordPos,
+		})
+		if writeHints {
+			encRecord, err := db.codec.EncodeHintRecord(logRecord.Key, logRecord.Type, logRecordPos)
+			if err != nil {
+				result.err = err
+				return result
+			}
+			hints = append(hints, encRecord...)
+		}
+
+		// 更新偏移量，下一次从文件新的偏移量处进行读取
+		offset += size
+	}
+	result.offset = offset
+
+	// 为没有 hint 文件的旧数据文件补上 hint 文件，下次启动时可以直接使用
+	if writeHints {
+		result.err = writeHintFile(db.options.DirPath, fileId, hints)
+	}
+	return result
+}
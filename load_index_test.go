@@ -0,0 +1,74 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_ParallelLoadIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-parallel-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.LoadConcurrency = 1
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 事务数据跨越多个文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 1000; i++ {
+		err := wb.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 2000; i < 3000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	// 顺序加载和并行加载的结果相同
+	var reclaimSize int64 = -1
+	for _, concurrency := range []int{1, 8} {
+		// 删除 hint 文件，从数据文件中解析
+		for fid := uint32(0); ; fid++ {
+			if _, err := os.Stat(data.GetDataFileName(dir, fid)); os.IsNotExist(err) {
+				break
+			}
+			assert.Nil(t, removeHintFile(dir, fid))
+		}
+
+		opts.LoadConcurrency = concurrency
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 2000, len(db2.ListKeys()))
+		assert.Equal(t, seqNo, db2.seqNo)
+		if reclaimSize >= 0 {
+			assert.Equal(t, reclaimSize, db2.reclaimSize)
+		}
+		reclaimSize = db2.reclaimSize
+		for i := 0; i < 3000; i++ {
+			_, err := db2.Get(utils.GetTestKey(i))
+			if i < 1000 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+		// 活跃文件的写偏移正确，可以继续写入
+		size, err := db2.activeFile.IoManager.Size()
+		assert.Nil(t, err)
+		assert.Equal(t, size, db2.activeFile.WriteOff)
+		assert.Nil(t, db2.Close())
+	}
+}
@@ -0,0 +1,209 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatVersion 数据目录的格式版本
+// LogRecord、LogRecordPos 以及 hint 等文件的编码方式发生不兼容的变化时需要增加版本号
+// 没有 MANIFEST 文件的旧目录视为版本 0，打开时会自动升级
+// 版本 2：LogRecord 的 type 字节中增加了 value 的压缩方式
+// 版本 3：LogRecord 增加了加密标识和密钥 id
+// 版本 4：LogRecord 的 type 字节中增加了校验算法，旧数据文件末尾写入文件结束标识
+// 版本 5：较大的 value 可以存储在 blob 文件中
+// 版本 6：blob 引用可以包含多个分块，用于流式写入的 value
+const formatVersion = 6
+
+// MANIFEST 文件中每条记录的 key
+const (
+	manifestVersionKey      = "format.version"
+	manifestIndexTypeKey    = "index.type"
+	manifestDataFileSizeKey = "data.file.size"
+	manifestCreatedAtKey    = "created.at"
+	manifestFileIdsKey      = "file.ids"
+	manifestBlobFileIdsKey  = "blob.file.ids"
+)
+
+// manifest 记录数据目录的格式版本、创建时的配置项以及当前有效的数据文件列表
+// 数据文件的新增和删除都会先更新 MANIFEST，打开时只加载其中记录的数据文件
+type manifest struct {
+	version      int
+	indexType    IndexerType
+	dataFileSize int64
+	createdAt    int64    // 数据目录的创建时间（UnixNano）
+	fileIds      []uint32 // 有效的数据文件 id，从小到大排序
+	blobFileIds  []uint32 // 有效的 blob 文件 id，从小到大排序
+}
+
+// readManifest 读取数据目录中的 MANIFEST 文件，文件不存在时返回 nil
+func readManifest(dirPath string) (*manifest, error) {
+	if _, err := os.Stat(filepath.Join(dirPath, data.ManifestFileName)); os.IsNotExist(err) {
+		return nil, nil
+	}
+	manifestFile, err := data.OpenManifestFile(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = manifestFile.Close()
+	}()
+
+	values := make(map[string]string)
+	var offset int64 = 0
+	for {
+		record, size, err := manifestFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, ErrManifestCorrupted
+		}
+		values[string(record.Key)] = string(record.Value)
+		offset += size
+	}
+	return decodeManifest(values)
+}
+
+// decodeManifest 解析 MANIFEST 中的记录，不认识的 key 会被忽略
+func decodeManifest(values map[string]string) (*manifest, error) {
+	m := &manifest{}
+	version, err := strconv.Atoi(values[manifestVersionKey])
+	if err != nil {
+		return nil, ErrManifestCorrupted
+	}
+	m.version = version
+	// 更新版本的格式可能无法解析，先返回版本号用于检查
+	if version > formatVersion {
+		return m, nil
+	}
+
+	indexType, err := strconv.Atoi(values[manifestIndexTypeKey])
+	if err != nil {
+		return nil, ErrManifestCorrupted
+	}
+	m.indexType = IndexerType(indexType)
+	if m.dataFileSize, err = strconv.ParseInt(values[manifestDataFileSizeKey], 10, 64); err != nil {
+		return nil, ErrManifestCorrupted
+	}
+	if m.createdAt, err = strconv.ParseInt(values[manifestCreatedAtKey], 10, 64); err != nil {
+		return nil, ErrManifestCorrupted
+	}
+	fileIds, ok := values[manifestFileIdsKey]
+	if !ok {
+		return nil, ErrManifestCorrupted
+	}
+	if m.fileIds, err = decodeFileIds(fileIds); err != nil {
+		return nil, err
+	}
+	// 版本 5 之前没有 blob 文件
+	if m.blobFileIds, err = decodeFileIds(values[manifestBlobFileIdsKey]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decodeFileIds 解析逗号分隔的文件 id 列表，返回从小到大排序的结果
+func decodeFileIds(s string) ([]uint32, error) {
+	var fileIds []uint32
+	if s != "" {
+		for _, item := range strings.Split(s, ",") {
+			fid, err := strconv.ParseUint(item, 10, 32)
+			if err != nil {
+				return nil, ErrManifestCorrupted
+			}
+			fileIds = append(fileIds, uint32(fid))
+		}
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds, nil
+}
+
+// encodeFileIds 将文件 id 列表编码成逗号分隔的字符串
+func encodeFileIds(fileIds []uint32) string {
+	items := make([]string, 0, len(fileIds))
+	for _, fid := range fileIds {
+		items = append(items, strconv.FormatUint(uint64(fid), 10))
+	}
+	return strings.Join(items, ",")
+}
+
+// encode 编码 MANIFEST 文件的内容
+func (m *manifest) encode() []byte {
+	values := [][2]string{
+		{manifestVersionKey, strconv.Itoa(m.version)},
+		{manifestIndexTypeKey, strconv.Itoa(int(m.indexType))},
+		{manifestDataFileSizeKey, strconv.FormatInt(m.dataFileSize, 10)},
+		{manifestCreatedAtKey, strconv.FormatInt(m.createdAt, 10)},
+		{manifestFileIdsKey, encodeFileIds(m.fileIds)},
+		{manifestBlobFileIdsKey, encodeFileIds(m.blobFileIds)},
+	}
+	var buf []byte
+	for _, kv := range values {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte(kv[0]), Value: []byte(kv[1])})
+		buf = append(buf, encRecord...)
+	}
+	return buf
+}
+
+// checkManifest 检查数据目录是否能用当前的版本和配置项打开
+func checkManifest(m *manifest, options Options) error {
+	if m.version > formatVersion {
+		return fmt.Errorf("%w: directory version %d, supported version %d", ErrUnsupportedFormat, m.version, formatVersion)
+	}
+	// B+ 树索引存储在磁盘上，和内存索引之间不能直接切换，BTree 和 ART 都从数据文件中加载，可以互相切换
+	if (m.indexType == BPlusTreeIndex) != (options.IndexerType == BPlusTreeIndex) {
+		return fmt.Errorf("%w: directory index type %d, options index type %d", ErrIndexTypeMismatch, m.indexType, options.IndexerType)
+	}
+	return nil
+}
+
+// saveManifest 将当前的数据文件和 blob 文件列表以及配置项写入 MANIFEST 文件，需要持有互斥锁
+// 新建文件之后、删除文件之前都要调用，保证 MANIFEST 中记录的文件都存在
+func (db *DB) saveManifest() error {
+	if db.manifest == nil {
+		db.manifest = &manifest{createdAt: time.Now().UnixNano()}
+	}
+	fileIds := make([]uint32, 0, len(db.olderFiles)+1)
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	blobFileIds := make([]uint32, 0, len(db.blobFiles))
+	for fid := range db.blobFiles {
+		blobFileIds = append(blobFileIds, fid)
+	}
+	sort.Slice(blobFileIds, func(i, j int) bool {
+		return blobFileIds[i] < blobFileIds[j]
+	})
+
+	db.manifest.fileIds = fileIds
+	db.manifest.blobFileIds = blobFileIds
+	return db.writeManifest()
+}
+
+// writeManifest 使用当前的配置项写入 MANIFEST 文件
+func (db *DB) writeManifest() error {
+	db.manifest.version = formatVersion
+	db.manifest.indexType = db.options.IndexerType
+	db.manifest.dataFileSize = db.options.DataFileSize
+	fileName := filepath.Join(db.options.DirPath, data.ManifestFileName)
+	return utils.WriteFileAtomic(fileName, db.manifest.encode(), fio.DataFilePerm)
+}
@@ -0,0 +1,140 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Manifest(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 新建的目录
+	m, err := readManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, formatVersion, m.version)
+	assert.Equal(t, BTreeIndex, m.indexType)
+	assert.Equal(t, opts.DataFileSize, m.dataFileSize)
+	assert.Equal(t, 0, len(m.fileIds))
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	checkFileIds := func() {
+		m, err := readManifest(dir)
+		assert.Nil(t, err)
+		assert.Equal(t, len(db.olderFiles)+1, len(m.fileIds))
+		for _, fid := range m.fileIds {
+			assert.True(t, db.dataFileExist(fid))
+		}
+	}
+	checkFileIds()
+
+	// merge 和 compaction 之后文件列表随之更新
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Compact())
+	checkFileIds()
+	assert.Nil(t, db.Merge())
+	checkFileIds()
+	assert.Nil(t, db.Close())
+
+	// 没有被 MANIFEST 记录并且 id 小于已记录文件的数据文件会被删除
+	m, err = readManifest(dir)
+	assert.Nil(t, err)
+	listed := make(map[uint32]bool)
+	for _, fid := range m.fileIds {
+		listed[fid] = true
+	}
+	var unlistedFileId uint32
+	for listed[unlistedFileId] {
+		unlistedFileId++
+	}
+	lastFileId := m.fileIds[len(m.fileIds)-1]
+	assert.Less(t, unlistedFileId, lastFileId)
+	unlisted, err := data.OpenDataFile(dir, unlistedFileId, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.Nil(t, unlisted.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataFileName(dir, unlistedFileId))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+
+	// 转换活跃文件之后 MANIFEST 的更新因为崩溃丢失，id 更大的新活跃文件中的数据需要保留
+	oldManifest, err := os.ReadFile(filepath.Join(dir, data.ManifestFileName))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	activeFileId := db.activeFile.FileId
+	for i := 0; db.activeFile.FileId == activeFileId; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(1000+i%1000), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("value")))
+	newActiveFileId := db.activeFile.FileId
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, data.ManifestFileName), oldManifest, 0644))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, newActiveFileId, db.activeFile.FileId)
+	val, err := db.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	checkFileIds()
+	assert.Nil(t, db.Close())
+
+	// 索引类型不兼容
+	bptreeOpts := opts
+	bptreeOpts.IndexerType = BPlusTreeIndex
+	_, err = Open(bptreeOpts)
+	assert.True(t, errors.Is(err, ErrIndexTypeMismatch))
+
+	// BTree 和 ART 索引可以切换
+	artOpts := opts
+	artOpts.IndexerType = ARTIndex
+	db, err = Open(artOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+	m, err = readManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, ARTIndex, m.indexType)
+
+	// MANIFEST 中记录的数据文件不存在
+	m.fileIds = append(m.fileIds, newActiveFileId+10)
+	assert.Nil(t, utils.WriteFileAtomic(filepath.Join(dir, data.ManifestFileName), m.encode(), fio.DataFilePerm))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileMissing))
+
+	// 更新的格式版本
+	m.version = formatVersion + 1
+	assert.Nil(t, utils.WriteFileAtomic(filepath.Join(dir, data.ManifestFileName), m.encode(), fio.DataFilePerm))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrUnsupportedFormat))
+
+	// 没有 MANIFEST 的旧目录会被升级
+	assert.Nil(t, os.Remove(filepath.Join(dir, data.ManifestFileName)))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	checkFileIds()
+}
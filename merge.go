@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
   "bitcask-go/data"
+  "bitcask-go/fio"
   "bitcask-go/utils"
   "io"
   "os"
@@ -9,15 +10,25 @@ import (
   "path/filepath"
   "sort"
   "strconv"
+  "strings"
+  "time"
 )
 
 const (
-  mergeDirName     = "-merge"
-  mergeFinishedKey = "merge.finished"
+  mergeDirName            = "-merge"
+  mergeFinishedKey        = "merge.finished"
+  mergeRemovedBlobFileKey = "merge.removed.blob.files"
 )
 
 // Merge 清理无效数据，生成 Hint 文件
-func (db *DB) Merge() error {
+// merge 完成后直接将新的数据文件替换到正在运行的数据库中，不需要重启
+func (db *DB) Merge() (err error) {
+  if db.options.ReadOnly {
+    return ErrReadOnly
+  }
+  if db.closed.Load() {
+    return ErrDatabaseClosed
+  }
   // 如果数据库为空，则直接返回
   if db.activeFile == nil {
     return nil
@@ -31,13 +42,19 @@ func (db *DB) Merge() error {
     return ErrMergeIsProgress
   }
 
-  // 查看可以 merge 的数据量是否达到了阈值
+  // 查看可以 merge 的数据量是否达到了阈值，blob 文件中的无效数据没有统计，不计算在内
   totalSize, err := utils.DirSize(db.options.DirPath)
   if err != nil {
     db.mu.Unlock()
     return err
   }
-  if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+  blobSize, err := db.blobFilesSize()
+  if err != nil {
+    db.mu.Unlock()
+    return err
+  }
+  dataSize := totalSize - blobSize
+  if dataSize <= 0 || float32(db.reclaimSize)/float32(dataSize) < db.options.DataFileMergeRatio {
     db.mu.Unlock()
     return ErrMergeRatioUnreached
   }
@@ -56,33 +73,50 @@ func (db *DB) Merge() error {
 
   db.isMerging = true
   defer func() {
+    db.mu.Lock()
     db.isMerging = false
+    db.mu.Unlock()
   }()
 
-  // 持久化当前活跃文件
-  if err := db.activeFile.Sync(); err != nil {
-    db.mu.Unlock()
-    return err
+  // 记录第一条没有参与 merge 的文件 id，merge 生成的文件 id 从 0 开始，必须小于这个 id
+  // 重写的记录可能比原来更大（例如开启了加密或者更换了校验算法），生成的文件会比参与 merge 的文件多
+  // 因此至少预留参与 merge 的文件数量两倍的 id，仍然不够时 merge 失败
+  nonMergeFileId := db.activeFile.FileId + 1
+  if reserved := 2 * uint32(len(db.olderFiles)+1); nonMergeFileId < reserved {
+    nonMergeFileId = reserved
   }
 
-  // 将当前活跃文件转化成旧数据文件
-  db.olderFiles[db.activeFile.FileId] = db.activeFile
-  // 打开新的活跃文件
-  if err := db.setActiveDataFile(); err != nil {
+  // 将当前活跃文件转化成旧数据文件，并打开新的活跃文件
+  if err := db.rotateActiveFileTo(nonMergeFileId); err != nil {
     db.mu.Unlock()
-    return nil
+    return err
   }
 
-  // 记录第一条没有参与 merge 的文件 id
-  nonMergeFileId := db.activeFile.FileId
-
   // 取出所有需要 merge 的文件
   var mergeFiles []*data.DataFile
   for _, file := range db.olderFiles {
     mergeFiles = append(mergeFiles, file)
   }
+
+  // 之后写入的 blob 都在新的 blob 文件中，现有的 blob 文件都可以回收，rotateActiveFile 中已经持久化
+  // 正在进行的流式写入引用的 blob 文件中的分块还没有被数据文件中的记录引用，不能回收
+  db.activeBlobFile = nil
+  blobFiles := make(map[uint32]*data.DataFile, len(db.blobFiles))
+  for fid, file := range db.blobFiles {
+    if db.streamingBlobs[fid] == 0 {
+      blobFiles[fid] = file
+    }
+  }
+  reclaimSize := db.reclaimSize
   db.mu.Unlock()
 
+  db.onMergeStart(MergeStartInfo{MergeFileNum: len(mergeFiles), ReclaimableSize: reclaimSize})
+  start := time.Now()
+  var reclaimed int64
+  defer func() {
+    db.onMergeEnd(MergeEndInfo{Duration: time.Since(start), ReclaimedBytes: reclaimed, Err: err})
+  }()
+
   // 将 merge 的文件从小到大进行排序，依次 merge
   sort.Slice(mergeFiles, func(i, j int) bool {
     return mergeFiles[i].FileId < mergeFiles[j].FileId
@@ -105,16 +139,31 @@ func (db *DB) Merge() error {
   mergeOptions := db.options
   mergeOptions.DirPath = mergePath
   mergeOptions.SyncWrites = false // 可以先暂时关闭持久化写入，提高性能。如果出现错误，merge 操作会失败，没持久化也不影响正确性
+  mergeOptions.AutoMergeInterval = 0 // 临时数据库不需要后台 merge
+  mergeOptions.IndexCheckpointOnClose = false
+  mergeOptions.BlobThreshold = 0 // 回收的 blob 直接写入原数据库的 blob 文件，临时数据库不能有 blob 文件
+  mergeOptions.Logger = nil // 临时数据库的日志和事件不通知用户
+  mergeOptions.EventListener = nil
   mergeDB, err := Open(mergeOptions)
   if err != nil {
     return err
   }
 
+  // 统计每个 blob 文件中的有效数据，挑选需要回收的 blob 文件
+  rewriteBlobFiles, removedBlobFileIds, err := db.pickBlobFilesToGC(mergeFiles, blobFiles)
+  if err != nil {
+    return err
+  }
+
   // 打开 Hint 文件存储索引
   hintFile, err := data.OpenHintFile(mergePath)
   if err != nil {
     return err
   }
+  hintFile.Codec = db.codec
+
+  // 已经过期而没有重写的数据，应用 merge 结果时需要从索引中删除
+  expiredPos := make(map[string]*data.LogRecordPos)
 
   // 遍历处理每个数据文件
   // 将数据和内存索引上的记录进行比较，符合条件才算有效数据
@@ -130,16 +179,41 @@ func (db *DB) Merge() error {
       }
       realKey, _ := parseLogRcordKeyWithSeqNo(logRecord.Key)
       logRecordPos := db.index.Get(realKey)
-      // 和内存索引中的索引位置进行比较，如果有效则重写
-      if logRecordPos != nil &&
+      // 和内存索引中的索引位置进行比较，如果有效且未过期则重写
+      isLive := logRecordPos != nil &&
         logRecordPos.Fid == dataFile.FileId &&
-        logRecordPos.Offset == offset {
+        logRecordPos.Offset == offset
+      if isLive && isExpired(logRecordPos, time.Now().UnixNano()) {
+        expiredPos[string(realKey)] = logRecordPos
+      } else if isLive {
         // 进行重写，因为是有效数据，所以可以直接清除事务序列号
         logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+        // 需要回收的 blob 文件中的有效数据重写到新的 blob 文件中
+        if logRecord.Compression == data.CompressionBlobRef && len(rewriteBlobFiles) > 0 {
+          ref, err := data.DecodeBlobRef(logRecord.Value)
+          if err != nil {
+            return err
+          }
+          var rewritten bool
+          for i, blobPos := range ref.Chunks {
+            if blobFile, ok := rewriteBlobFiles[blobPos.Fid]; ok {
+              if ref.Chunks[i], err = db.rewriteBlob(blobFile, blobPos); err != nil {
+                return err
+              }
+              rewritten = true
+            }
+          }
+          if rewritten {
+            logRecord.Value = data.EncodeBlobRef(ref)
+          }
+        }
         pos, err := mergeDB.appendLogRecord(logRecord)
         if err != nil {
           return err
         }
+        if pos.Fid >= nonMergeFileId {
+          return ErrMergeFileIdsExhausted
+        }
         // 将当前位置索引写到 Hint 文件中
         if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
           return err
@@ -157,6 +231,16 @@ func (db *DB) Merge() error {
   if err := mergeDB.Sync(); err != nil {
     return err
   }
+  db.mu.Lock()
+  if db.closed.Load() {
+    err = ErrDatabaseClosed
+  } else {
+    err = db.syncBlobFile()
+  }
+  db.mu.Unlock()
+  if err != nil {
+    return err
+  }
 
   // 写标识 merge 完成的文件
   mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
@@ -167,7 +251,21 @@ func (db *DB) Merge() error {
     Key:   []byte(mergeFinishedKey),
     Value: []byte(strconv.Itoa(int(nonMergeFileId))), // 记录最近没有 merge 的 file id；比他小的 file id 都参与了 merge
   }
-  encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
+  encRecord, _, err := db.codec.EncodeLogRecord(mergeFinRecord)
+  if err != nil {
+    return err
+  }
+  if err := mergeFinishedFile.Write(encRecord); err != nil {
+    return err
+  }
+  // 记录可以删除的 blob 文件，中途崩溃时下次启动会删除
+  encRecord, _, err = db.codec.EncodeLogRecord(&data.LogRecord{
+    Key:   []byte(mergeRemovedBlobFileKey),
+    Value: []byte(encodeFileIds(removedBlobFileIds)),
+  })
+  if err != nil {
+    return err
+  }
   if err := mergeFinishedFile.Write(encRecord); err != nil {
     return err
   }
@@ -175,7 +273,210 @@ func (db *DB) Merge() error {
     return err
   }
 
-  return nil
+  // 关闭 merge 用的临时文件和数据库实例，之后才能将文件转移到原数据库中
+  if err := mergeFinishedFile.Close(); err != nil {
+    return err
+  }
+  if err := hintFile.Close(); err != nil {
+    return err
+  }
+  if err := mergeDB.Close(); err != nil {
+    return err
+  }
+
+  reclaimed, err = db.applyMergeFiles(mergePath, nonMergeFileId, removedBlobFileIds, expiredPos)
+  return err
+}
+
+// applyMergeFiles 将 merge 目录中的文件替换到正在运行的数据库中
+// 文件通过硬链接转移，merge 目录在全部完成之前保持完整，中途崩溃时下次启动会由 loadMergeFiles 重新完成替换
+// 替换后根据 hint 文件将索引指向新的数据文件，被替换的旧文件和回收的 blob 文件如果还被快照或迭代器引用，则在引用释放后关闭
+// 返回删除的文件大小减去新生成的数据文件大小，即回收的磁盘空间
+func (db *DB) applyMergeFiles(mergePath string, nonMergeFileId uint32, removedBlobFileIds []uint32,
+  expiredPos map[string]*data.LogRecordPos) (int64, error) {
+  dirEntries, err := os.ReadDir(mergePath)
+  if err != nil {
+    return 0, err
+  }
+  var mergeFileIds []uint32
+  var fileNames []string
+  for _, entry := range dirEntries {
+    name := entry.Name()
+    if strings.HasSuffix(name, data.DataFileNameSuffix) {
+      fileId, err := strconv.Atoi(strings.Split(name, ".")[0])
+      if err != nil {
+        return 0, ErrDataDirectoryCorrupted
+      }
+      // 和 merge 之后写入的文件 id 冲突
+      if uint32(fileId) >= nonMergeFileId {
+        return 0, ErrMergeFileIdsExhausted
+      }
+      mergeFileIds = append(mergeFileIds, uint32(fileId))
+      fileNames = append(fileNames, name)
+    }
+    if name == data.HintFileName || name == data.MergeFinishedFileName {
+      fileNames = append(fileNames, name)
+    }
+  }
+
+  // 先读出 hint 文件中的全部索引，避免在替换了数据文件之后才出错
+  hintKeys, hintPos, err := readHintRecords(mergePath, db.codec)
+  if err != nil {
+    return 0, err
+  }
+
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  // merge 期间数据库被关闭了，merge 目录在下次启动时加载
+  if db.closed.Load() {
+    return 0, ErrDatabaseClosed
+  }
+
+  // 替换期间同一个文件 id 可能对应新旧两个文件，不持有锁的读操作发现替换发生过时改为持有锁重新读取
+  db.fileSwaps.Add(1)
+  defer func() {
+    db.publishFiles()
+    db.fileSwaps.Add(1)
+  }()
+
+  // 数据的位置发生了变化，之前的 index checkpoint 已经失效
+  if err := db.invalidateIndexCheckpoint(); err != nil {
+    return 0, err
+  }
+
+  // 删除原数据库中已经被 merge 了的旧数据文件，已经打开的文件依然可以读取
+  for fid := range db.olderFiles {
+    if fid < nonMergeFileId {
+      if err := os.Remove(data.GetDataFileName(db.options.DirPath, fid)); err != nil {
+        return 0, err
+      }
+      if err := removeHintFile(db.options.DirPath, fid); err != nil {
+        return 0, err
+      }
+    }
+  }
+  // 将 merge 完成后的文件链接到原数据库中
+  for _, fileName := range fileNames {
+    srcPath := filepath.Join(mergePath, fileName)
+    destPath := filepath.Join(db.options.DirPath, fileName)
+    if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+      return 0, err
+    }
+    if err := os.Link(srcPath, destPath); err != nil {
+      return 0, err
+    }
+  }
+
+  // 打开新的数据文件
+  var reclaimed int64
+  mergedFiles := make(map[uint32]*data.DataFile, len(mergeFileIds))
+  for _, fid := range mergeFileIds {
+    dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+    var size int64
+    if err == nil {
+      size, err = dataFile.IoManager.Size()
+      mergedFiles[fid] = dataFile
+    }
+    if err != nil {
+      for _, file := range mergedFiles {
+        _ = file.Close()
+      }
+      return 0, err
+    }
+    dataFile.Codec = db.codec
+    reclaimed -= size
+  }
+
+  // 删除已经过期的数据的索引，需要在更新索引之前进行，避免和新的位置混淆
+  for key, pos := range expiredPos {
+    curPos := db.index.Get([]byte(key))
+    if curPos != nil && curPos.Fid == pos.Fid && curPos.Offset == pos.Offset {
+      db.index.Delete([]byte(key))
+    }
+  }
+
+  // 替换旧的数据文件，旧文件中的无效数据随文件一起回收
+  for fid, dataFile := range db.olderFiles {
+    if fid >= nonMergeFileId {
+      continue
+    }
+    if size, err := dataFile.IoManager.Size(); err == nil {
+      reclaimed += size
+    }
+    delete(db.olderFiles, fid)
+    db.reclaimSize -= db.fileDeadBytes[fid]
+    delete(db.fileDeadBytes, fid)
+    if err := db.retireDataFile(dataFile); err != nil {
+      return 0, err
+    }
+  }
+  for fid, dataFile := range mergedFiles {
+    db.olderFiles[fid] = dataFile
+  }
+  // 回收的 blob 文件先从 MANIFEST 中去掉再删除
+  var removedBlobFiles []*data.DataFile
+  for _, fid := range removedBlobFileIds {
+    if blobFile, ok := db.blobFiles[fid]; ok {
+      removedBlobFiles = append(removedBlobFiles, blobFile)
+      delete(db.blobFiles, fid)
+    }
+  }
+  if err := db.saveManifest(); err != nil {
+    return 0, err
+  }
+  for _, blobFile := range removedBlobFiles {
+    if size, err := blobFile.IoManager.Size(); err == nil {
+      reclaimed += size
+    }
+    if err := os.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId)); err != nil {
+      return 0, err
+    }
+    if err := db.retireDataFile(blobFile); err != nil {
+      return 0, err
+    }
+  }
+
+  // 更新索引，merge 期间的写入都在新的文件中，索引仍然指向参与 merge 的文件，说明数据没有被修改过
+  for i, key := range hintKeys {
+    curPos := db.index.Get(key)
+    if curPos != nil && curPos.Fid < nonMergeFileId {
+      db.index.Put(key, hintPos[i])
+    } else {
+      // merge 期间被修改或删除了，重写的数据已经无效
+      db.markReclaimable(hintPos[i])
+    }
+  }
+
+  return reclaimed, os.RemoveAll(mergePath)
+}
+
+// readHintRecords 读取目录中 hint 文件的全部索引
+func readHintRecords(dirPath string, codec *data.Codec) ([][]byte, []*data.LogRecordPos, error) {
+  hintFile, err := data.OpenHintFile(dirPath)
+  if err != nil {
+    return nil, nil, err
+  }
+  hintFile.Codec = codec
+  defer func() {
+    _ = hintFile.Close()
+  }()
+
+  var keys [][]byte
+  var positions []*data.LogRecordPos
+  var offset int64 = 0
+  for {
+    logRecord, size, err := hintFile.ReadLogRecord(offset)
+    if err != nil {
+      if err == io.EOF {
+        break
+      }
+      return nil, nil, err
+    }
+    keys = append(keys, logRecord.Key)
+    positions = append(positions, data.DecodeLogRecordPos(logRecord.Value))
+    offset += size
+  }
+  return keys, positions, nil
 }
 
 // 得到 merge 用的数据库路径
@@ -187,16 +488,13 @@ func (db *DB) getMergePath() string {
 }
 
 // 加载 merge 数据目录
+// 和 applyMergeFiles 一样通过硬链接转移文件，全部完成之后才删除 merge 目录，中途崩溃时下次启动会重新完成替换
 func (db *DB) loadMergeFiles() error {
   mergePath := db.getMergePath()
   // merge 目录不存在则直接返回
   if _, err := os.Stat(mergePath); os.IsNotExist(err) {
     return nil
   }
-  // 加载完成后要删除 merge 目录
-  defer func() {
-    os.RemoveAll(mergePath)
-  }()
 
   dirEntries, err := os.ReadDir(mergePath)
   if err != nil {
@@ -206,27 +504,49 @@ func (db *DB) loadMergeFiles() error {
   // 查找表示 merge 完成的文件，判断 merge 是否正常处理完成
   var mergeFinished bool
   var mergeFileNames []string // 保存 merge 后生成的文件名
+  var mergeFileIds []uint32
   for _, entry := range dirEntries {
-    if entry.Name() == data.MergeFinishedFileName {
+    name := entry.Name()
+    if name == data.MergeFinishedFileName {
       mergeFinished = true
     }
-    if entry.Name() == data.SeqNoFileName {
+    // 临时数据库自身的文件不需要转移
+    if name == data.SeqNoFileName || name == data.ManifestFileName || name == fileLockName {
       continue
     }
-    mergeFileNames = append(mergeFileNames, entry.Name())
+    if strings.HasSuffix(name, data.DataFileNameSuffix) {
+      fileId, err := strconv.Atoi(strings.Split(name, ".")[0])
+      if err != nil {
+        return ErrDataDirectoryCorrupted
+      }
+      mergeFileIds = append(mergeFileIds, uint32(fileId))
+    }
+    mergeFileNames = append(mergeFileNames, name)
   }
 
-  // 没有 merge 正常完成则直接正常返回
+  // 没有 merge 正常完成则直接删除 merge 目录
   if !mergeFinished {
-    return nil
+    return os.RemoveAll(mergePath)
   }
 
-  // 获得第一个未被 merge 的文件 id
+  // 获得第一个未被 merge 的文件 id，以及回收的 blob 文件
   nonMergeFileId, err := db.getNonMergeFileId(mergePath)
   if err != nil {
-    return nil
+    return err
+  }
+  removedBlobFileIds, err := db.getRemovedBlobFileIds(mergePath)
+  if err != nil {
+    return err
+  }
+  for _, fid := range mergeFileIds {
+    if fid >= nonMergeFileId {
+      return ErrMergeFileIdsExhausted
+    }
+  }
+  // 删除原数据库中已经被 merge 了的旧数据文件，以及已经失效的 index checkpoint
+  if err := db.invalidateIndexCheckpoint(); err != nil {
+    return err
   }
-  // 删除原数据库中已经被 merge 了的旧数据文件
   var fileId uint32 = 0
   for ; fileId < nonMergeFileId; fileId++ {
     fileName := data.GetDataFileName(db.options.DirPath, fileId)
@@ -235,18 +555,56 @@ func (db *DB) loadMergeFiles() error {
         return err
       }
     }
+    if err := removeHintFile(db.options.DirPath, fileId); err != nil {
+      return err
+    }
   }
 
-  // 将 merge 完成后的新的数据文件移动到原数据库中
+  // 将 merge 完成后的新的数据文件链接到原数据库中
   for _, fileName := range mergeFileNames {
-    // 更改路径即可完成转移
     srcPath := filepath.Join(mergePath, fileName)
     destPath := filepath.Join(db.options.DirPath, fileName)
-    if err := os.Rename(srcPath, destPath); err != nil {
+    if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+      return err
+    }
+    if err := os.Link(srcPath, destPath); err != nil {
       return err
     }
   }
-  return nil
+
+  // 更新 MANIFEST 中的数据文件和 blob 文件列表，没有 MANIFEST 的旧目录在加载数据文件时会根据目录中的文件生成
+  removedBlobs := make(map[uint32]struct{}, len(removedBlobFileIds))
+  for _, fid := range removedBlobFileIds {
+    removedBlobs[fid] = struct{}{}
+  }
+  if db.manifest != nil {
+    fileIds := mergeFileIds
+    for _, fid := range db.manifest.fileIds {
+      if fid >= nonMergeFileId {
+        fileIds = append(fileIds, fid)
+      }
+    }
+    sort.Slice(fileIds, func(i, j int) bool {
+      return fileIds[i] < fileIds[j]
+    })
+    db.manifest.fileIds = fileIds
+    var blobFileIds []uint32
+    for _, fid := range db.manifest.blobFileIds {
+      if _, ok := removedBlobs[fid]; !ok {
+        blobFileIds = append(blobFileIds, fid)
+      }
+    }
+    db.manifest.blobFileIds = blobFileIds
+    if err := db.writeManifest(); err != nil {
+      return err
+    }
+  }
+  for fid := range removedBlobs {
+    if err := os.Remove(data.GetBlobFileName(db.options.DirPath, fid)); err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+  return os.RemoveAll(mergePath)
 }
 
 // getNonMergeFileId 读取第一个未被 merge 的文件 id
@@ -255,6 +613,7 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
   if err != nil {
     return 0, err
   }
+  mergeFinishedFile.Codec = db.codec
 
   // 读取存储着第一个未被 merge 的文件 id 的 LogRecord
   // 其 value 就是要的文件 id 值
@@ -269,6 +628,109 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
   return uint32(nonMergeFileId), nil
 }
 
+// getRemovedBlobFileIds 读取 merge 时回收的 blob 文件 id，旧版本的 merge 完成标识中没有这条记录
+func (db *DB) getRemovedBlobFileIds(dirPath string) ([]uint32, error) {
+  mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+  if err != nil {
+    return nil, err
+  }
+  mergeFinishedFile.Codec = db.codec
+  defer func() {
+    _ = mergeFinishedFile.Close()
+  }()
+
+  var offset int64 = 0
+  for {
+    record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+    if err != nil {
+      if err == io.EOF {
+        return nil, nil
+      }
+      return nil, err
+    }
+    if string(record.Key) == mergeRemovedBlobFileKey {
+      return decodeFileIds(string(record.Value))
+    }
+    offset += size
+  }
+}
+
+// pickBlobFilesToGC 统计参与 merge 的数据文件中有效数据引用的 blob 大小，挑选需要回收的 blob 文件
+// 没有有效数据的 blob 文件直接删除，无效数据比例达到 BlobGCRatio 的 blob 文件重写其中的有效数据后删除
+// BlobGCRatio 为 0 时不重写，避免每次 merge 都重写全部较大的 value
+// 统计之后数据只会变得更少，不会有新的数据引用这些 blob 文件，因此 merge 完成后可以安全删除
+func (db *DB) pickBlobFilesToGC(mergeFiles []*data.DataFile, blobFiles map[uint32]*data.DataFile) (
+  rewrite map[uint32]*data.DataFile, removed []uint32, err error) {
+  if len(blobFiles) == 0 {
+    return nil, nil, nil
+  }
+
+  liveBytes := make(map[uint32]int64, len(blobFiles))
+  now := time.Now().UnixNano()
+  for _, dataFile := range mergeFiles {
+    var offset int64 = 0
+    for {
+      logRecord, size, err := dataFile.ReadLogRecord(offset)
+      if err != nil {
+        if err == io.EOF {
+          break
+        }
+        return nil, nil, err
+      }
+      if logRecord.Compression == data.CompressionBlobRef {
+        realKey, _ := parseLogRcordKeyWithSeqNo(logRecord.Key)
+        logRecordPos := db.index.Get(realKey)
+        if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset &&
+          !isExpired(logRecordPos, now) {
+          ref, err := data.DecodeBlobRef(logRecord.Value)
+          if err != nil {
+            return nil, nil, err
+          }
+          for _, blobPos := range ref.Chunks {
+            liveBytes[blobPos.Fid] += int64(blobPos.Size)
+          }
+        }
+      }
+      offset += size
+    }
+  }
+
+  rewrite = make(map[uint32]*data.DataFile)
+  for fid, blobFile := range blobFiles {
+    size, err := blobFile.IoManager.Size()
+    if err != nil {
+      return nil, nil, err
+    }
+    live := liveBytes[fid]
+    ratio := db.options.BlobGCRatio
+    if live > 0 && (size == 0 || ratio == 0 || float32(size-live)/float32(size) < ratio) {
+      continue
+    }
+    if live > 0 {
+      rewrite[fid] = blobFile
+    }
+    removed = append(removed, fid)
+  }
+  sort.Slice(removed, func(i, j int) bool {
+    return removed[i] < removed[j]
+  })
+  return rewrite, removed, nil
+}
+
+// rewriteBlob 将需要回收的 blob 文件中的一个 blob 重写到当前的活跃 blob 文件中，返回新的位置
+func (db *DB) rewriteBlob(blobFile *data.DataFile, blobPos *data.LogRecordPos) (*data.LogRecordPos, error) {
+  blobRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+  if err != nil {
+    return nil, err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  if db.closed.Load() {
+    return nil, ErrDatabaseClosed
+  }
+  return db.appendBlobRecord(blobRecord)
+}
+
 // 从 hint 文件中加载索引
 func (db *DB) loadIndexFromHintFile() error {
   // 查看 hint 文件是否存在
@@ -282,9 +744,14 @@ func (db *DB) loadIndexFromHintFile() error {
   if err != nil {
     return err
   }
+  hintFile.Codec = db.codec
+  defer func() {
+    _ = hintFile.Close()
+  }()
 
   // 读取文件中的索引
   var offset int64 = 0
+  now := time.Now().UnixNano()
   for {
     logRecord, size, err := hintFile.ReadLogRecord(offset)
     if err != nil {
@@ -294,9 +761,12 @@ func (db *DB) loadIndexFromHintFile() error {
       return err
     }
 
-    // 解码得到实际位置索引信息，并更新索引
+    // 解码得到实际位置索引信息，并更新索引，已经过期的数据不再加载
+    // 所在文件已经被 compaction 删除的数据，其有效的部分已经重写到了更新的文件中
     pos := data.DecodeLogRecordPos(logRecord.Value)
-    db.index.Put(logRecord.Key, pos)
+    if !isExpired(pos, now) && db.dataFileExist(pos.Fid) {
+      db.index.Put(logRecord.Key, pos)
+    }
     offset += size
   }
   return nil
@@ -2,10 +2,12 @@ package bitcask_go
 
 import (
   "bitcask-go/utils"
+  "bytes"
   "github.com/stretchr/testify/assert"
   "os"
   "sync"
   "testing"
+  "time"
 )
 
 // 没有任何数据的情况下进行 merge
@@ -201,3 +203,152 @@ func TestDB_Merge5(t *testing.T) {
     assert.NotNil(t, val)
   }
 }
+
+// 过期的数据在 merge 时被清理
+func TestDB_Merge_Expired(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-merge-ttl")
+  opts.DataFileSize = 32 * 1024 * 1024
+  opts.DataFileMergeRatio = 0
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  for i := 0; i < 10000; i++ {
+    err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Millisecond*50)
+    assert.Nil(t, err)
+  }
+  for i := 10000; i < 20000; i++ {
+    err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Hour)
+    assert.Nil(t, err)
+  }
+  time.Sleep(time.Millisecond * 100)
+
+  err = db.Merge()
+  assert.Nil(t, err)
+
+  // 重启校验
+  err = db.Close()
+  assert.Nil(t, err)
+
+  db2, err := Open(opts)
+  defer func() {
+    _ = db2.Close()
+  }()
+  assert.Nil(t, err)
+  keys := db2.ListKeys()
+  assert.Equal(t, 10000, len(keys))
+  ttl, err := db2.TTL(utils.GetTestKey(10000))
+  assert.Nil(t, err)
+  assert.True(t, ttl > 0)
+}
+
+// merge 完成后不需要重启，正在运行的数据库直接读取新的数据文件
+func TestDB_Merge_Online(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+  opts.DataFileSize = 1024 * 1024
+  opts.DataFileMergeRatio = 0
+  opts.DirPath = dir
+  db, err := Open(opts)
+  defer destroyDB(db)
+  assert.Nil(t, err)
+  assert.NotNil(t, db)
+
+  for i := 0; i < 10000; i++ {
+    err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+    assert.Nil(t, err)
+  }
+  snap, err := db.NewSnapshot()
+  assert.Nil(t, err)
+  for i := 0; i < 5000; i++ {
+    err := db.Delete(utils.GetTestKey(i))
+    assert.Nil(t, err)
+  }
+  fileNum := mustStat(t, db).DataFileNum
+
+  err = db.Merge()
+  assert.Nil(t, err)
+  _, err = os.Stat(db.getMergePath())
+  assert.True(t, os.IsNotExist(err))
+  assert.Less(t, mustStat(t, db).DataFileNum, fileNum)
+
+  checkData := func(db *DB) {
+    keys := db.ListKeys()
+    assert.Equal(t, 5000, len(keys))
+    for i := 0; i < 10000; i++ {
+      _, err := db.Get(utils.GetTestKey(i))
+      if i < 5000 {
+        assert.Equal(t, ErrKeyNotFound, err)
+      } else {
+        assert.Nil(t, err)
+      }
+    }
+  }
+  checkData(db)
+
+  // 被替换的旧文件依然可以通过快照读取
+  assert.NotEqual(t, 0, len(db.obsoleteFiles))
+  for i := 0; i < 10000; i++ {
+    val, err := snap.Get(utils.GetTestKey(i))
+    assert.Nil(t, err)
+    assert.NotNil(t, val)
+  }
+  snap.Release()
+  assert.Equal(t, 0, len(db.obsoleteFiles))
+
+  // 重启校验
+  err = db.Close()
+  assert.Nil(t, err)
+  db2, err := Open(opts)
+  defer func() {
+    _ = db2.Close()
+  }()
+  assert.Nil(t, err)
+  checkData(db2)
+}
+
+// 开启加密后重写的记录变大，merge 生成的文件比参与 merge 的文件多，文件 id 不能和之后写入的文件冲突
+func TestDB_Merge_OutputGrows(t *testing.T) {
+  opts := DefaultOptions
+  dir, _ := os.MkdirTemp("", "bitcask-go-merge-grows")
+  opts.DirPath = dir
+  opts.DataFileSize = 64 * 1024
+  opts.DataFileMergeRatio = 0
+  db, err := Open(opts)
+  assert.Nil(t, err)
+  // 写满两个数据文件
+  for i := 0; db.activeFile == nil || db.activeFile.FileId < 1 || db.activeFile.WriteOff < opts.DataFileSize-1024; i++ {
+    assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+  }
+  keyNum := len(db.ListKeys())
+  assert.Nil(t, db.Close())
+
+  opts.EncryptionKey = bytes.Repeat([]byte("k"), 32)
+  db, err = Open(opts)
+  defer func() {
+    destroyDB(db)
+  }()
+  assert.Nil(t, err)
+  assert.Nil(t, db.Merge())
+  for i := 0; i < 10; i++ {
+    assert.Nil(t, db.Put(utils.GetTestKey(keyNum+i), utils.RandomValue(64)))
+  }
+
+  checkData := func(db *DB) {
+    assert.Equal(t, keyNum+10, len(db.ListKeys()))
+    for i := 0; i < keyNum+10; i++ {
+      _, err := db.Get(utils.GetTestKey(i))
+      assert.Nil(t, err)
+    }
+  }
+  checkData(db)
+
+  // 重启校验
+  assert.Nil(t, db.Close())
+  db, err = Open(opts)
+  assert.Nil(t, err)
+  checkData(db)
+}
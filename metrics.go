@@ -0,0 +1,231 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// indexEntryOverhead 估算内存索引大小时每个 key 除了 key 本身以外占用的内存，包括索引节点和位置信息
+const indexEntryOverhead = 64
+
+var (
+	// latencyBuckets 读写操作耗时的直方图区间上界，单位为秒，从 10us 到 10s
+	latencyBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+	// batchSizeBuckets 批量提交的记录数量的直方图区间上界
+	batchSizeBuckets = []float64{1, 4, 16, 64, 256, 1024, 4096, 16384}
+)
+
+// Metrics 存储引擎运行期间的统计指标，由 DB.Metrics 返回某一时刻的拷贝
+// 计数类的指标从数据库打开时开始累计，耗时的单位都是秒
+type Metrics struct {
+	PutLatency          HistogramSnapshot // Put 的耗时，包括等待持久化的时间
+	GetLatency          HistogramSnapshot // Get 的耗时
+	DeleteLatency       HistogramSnapshot // Delete 的耗时，包括等待持久化的时间
+	BytesWritten        uint64            // 写入数据文件和 blob 文件的字节数
+	SyncDuration        HistogramSnapshot // 活跃数据文件和 blob 文件每次持久化的耗时，Count 为持久化的次数
+	FileRotations       uint64            // 活跃数据文件写满后转换的次数
+	MergeDuration       HistogramSnapshot // 每次 merge 的耗时，包括失败的 merge
+	MergeReclaimedBytes int64             // merge 累计回收的磁盘空间，不计入回收量为负数的 merge，只会增加
+	BatchCommitSize     HistogramSnapshot // WriteBatch 和事务每次提交的记录数量
+	IndexMemoryBytes    int64             // 内存索引占用内存的估算值，B+ 树索引存储在磁盘上，为 0
+	KeyNum              uint              // key 的总数量
+	DataFileNum         uint              // 数据文件的数量
+	ReclaimableSize     int64             // 可以通过 merge 回收的数据量
+}
+
+// HistogramSnapshot 直方图在某一时刻的拷贝
+type HistogramSnapshot struct {
+	Buckets []float64 // 每个区间的上界，从小到大排列，不包括 +Inf
+	Counts  []uint64  // 不大于对应上界的观测值数量，是累计值
+	Count   uint64    // 观测值的总数量
+	Sum     float64   // 观测值的总和
+}
+
+// histogram 记录观测值分布的直方图，所有操作都是原子的
+type histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64 // 每个区间的观测值数量，最后一个是超过所有上界的数量
+	sumBits atomic.Uint64   // 观测值总和的 float64 位表示
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+// observe 记录一个观测值
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(h.buckets) && v > h.buckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// observeSince 记录从 start 开始到现在的耗时
+func (h *histogram) observeSince(start time.Time) {
+	h.observe(time.Since(start).Seconds())
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Buckets: h.buckets,
+		Counts:  make([]uint64, len(h.buckets)),
+		Sum:     math.Float64frombits(h.sumBits.Load()),
+	}
+	for i := range h.counts {
+		s.Count += h.counts[i].Load()
+		if i < len(h.buckets) {
+			s.Counts[i] = s.Count
+		}
+	}
+	return s
+}
+
+// metrics 数据库运行期间累计的统计指标
+type metrics struct {
+	putLatency          *histogram
+	getLatency          *histogram
+	deleteLatency       *histogram
+	bytesWritten        atomic.Uint64
+	syncDuration        *histogram
+	fileRotations       atomic.Uint64
+	mergeDuration       *histogram
+	mergeReclaimedBytes atomic.Int64
+	batchCommitSize     *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		putLatency:      newHistogram(latencyBuckets),
+		getLatency:      newHistogram(latencyBuckets),
+		deleteLatency:   newHistogram(latencyBuckets),
+		syncDuration:    newHistogram(latencyBuckets),
+		mergeDuration:   newHistogram([]float64{.1, .5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}),
+		batchCommitSize: newHistogram(batchSizeBuckets),
+	}
+}
+
+// meteredIndex 统计内存索引中 key 的总长度，用于估算索引占用的内存
+type meteredIndex struct {
+	index.Indexer
+	keyBytes atomic.Int64
+}
+
+func (mi *meteredIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos := mi.Indexer.Put(key, pos)
+	if oldPos == nil {
+		mi.keyBytes.Add(int64(len(key)))
+	}
+	return oldPos
+}
+
+func (mi *meteredIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	oldPos, ok := mi.Indexer.Delete(key)
+	if ok {
+		mi.keyBytes.Add(-int64(len(key)))
+	}
+	return oldPos, ok
+}
+
+// memoryUsage 估算索引占用的内存
+func (mi *meteredIndex) memoryUsage() int64 {
+	return mi.keyBytes.Load() + int64(mi.Size())*indexEntryOverhead
+}
+
+// Metrics 返回数据库当前的统计指标
+func (db *DB) Metrics() (*Metrics, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	m := db.metrics
+	dataFiles := uint(len(db.olderFiles))
+	if db.activeFile != nil {
+		dataFiles++
+	}
+	var indexMemory int64
+	if mi, ok := db.index.(*meteredIndex); ok {
+		indexMemory = mi.memoryUsage()
+	}
+	return &Metrics{
+		PutLatency:          m.putLatency.snapshot(),
+		GetLatency:          m.getLatency.snapshot(),
+		DeleteLatency:       m.deleteLatency.snapshot(),
+		BytesWritten:        m.bytesWritten.Load(),
+		SyncDuration:        m.syncDuration.snapshot(),
+		FileRotations:       m.fileRotations.Load(),
+		MergeDuration:       m.mergeDuration.snapshot(),
+		MergeReclaimedBytes: m.mergeReclaimedBytes.Load(),
+		BatchCommitSize:     m.batchCommitSize.snapshot(),
+		IndexMemoryBytes:    indexMemory,
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimSize,
+	}, nil
+}
+
+// WriteText 按照 Prometheus 的文本格式输出所有指标，指标名称以 bitcask_ 开头
+func (m *Metrics) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeHistogram(bw, "bitcask_put_duration_seconds", "Latency of Put operations.", m.PutLatency)
+	writeHistogram(bw, "bitcask_get_duration_seconds", "Latency of Get operations.", m.GetLatency)
+	writeHistogram(bw, "bitcask_delete_duration_seconds", "Latency of Delete operations.", m.DeleteLatency)
+	writeMetric(bw, "bitcask_written_bytes_total", "counter", "Bytes written to data and blob files.", float64(m.BytesWritten))
+	writeHistogram(bw, "bitcask_sync_duration_seconds", "Latency of fsync on active data and blob files.", m.SyncDuration)
+	writeMetric(bw, "bitcask_file_rotations_total", "counter", "Number of active data file rotations.", float64(m.FileRotations))
+	writeHistogram(bw, "bitcask_merge_duration_seconds", "Duration of merges.", m.MergeDuration)
+	writeMetric(bw, "bitcask_merge_reclaimed_bytes_total", "counter", "Disk space reclaimed by merges.", float64(m.MergeReclaimedBytes))
+	writeHistogram(bw, "bitcask_batch_commit_records", "Number of records in committed write batches and transactions.", m.BatchCommitSize)
+	writeMetric(bw, "bitcask_index_memory_bytes", "gauge", "Estimated memory used by the in-memory index.", float64(m.IndexMemoryBytes))
+	writeMetric(bw, "bitcask_keys", "gauge", "Number of keys.", float64(m.KeyNum))
+	writeMetric(bw, "bitcask_data_files", "gauge", "Number of data files.", float64(m.DataFileNum))
+	writeMetric(bw, "bitcask_reclaimable_bytes", "gauge", "Bytes that can be reclaimed by merge.", float64(m.ReclaimableSize))
+	return bw.Flush()
+}
+
+func writeMetric(w *bufio.Writer, name, typ, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, formatFloat(value))
+}
+
+func writeHistogram(w *bufio.Writer, name, help string, h HistogramSnapshot) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), h.Counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.Sum), name, h.Count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出数据库指标的 http.Handler，可以挂载到 /metrics
+func MetricsHandler(db *DB) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		m, err := db.Metrics()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WriteText(writer)
+	})
+}
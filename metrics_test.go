@@ -0,0 +1,109 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 10})
+	h.observe(0.5)
+	h.observe(1)
+	h.observe(5)
+	h.observe(100)
+
+	s := h.snapshot()
+	assert.Equal(t, []float64{1, 10}, s.Buckets)
+	assert.Equal(t, []uint64{2, 3}, s.Counts)
+	assert.Equal(t, uint64(4), s.Count)
+	assert.Equal(t, 106.5, s.Sum)
+}
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 500; i < 600; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commit())
+
+	m, err := db.Metrics()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), m.PutLatency.Count)
+	assert.Equal(t, uint64(500), m.DeleteLatency.Count)
+	assert.Equal(t, uint64(100), m.GetLatency.Count)
+	assert.Equal(t, uint64(1), m.BatchCommitSize.Count)
+	assert.Equal(t, float64(10), m.BatchCommitSize.Sum)
+	assert.Greater(t, m.BytesWritten, uint64(1000*128))
+	assert.GreaterOrEqual(t, m.SyncDuration.Count, uint64(1501))
+	assert.Greater(t, m.FileRotations, uint64(0))
+	assert.Equal(t, uint(510), m.KeyNum)
+	assert.Equal(t, uint(m.FileRotations+1), m.DataFileNum)
+	assert.Greater(t, m.ReclaimableSize, int64(0))
+	keyBytes := 0
+	for _, key := range db.ListKeys() {
+		keyBytes += len(key)
+	}
+	assert.Equal(t, int64(keyBytes+510*indexEntryOverhead), m.IndexMemoryBytes)
+
+	// merge 回收了删除和覆盖的数据
+	assert.Nil(t, db.Merge())
+	m, err = db.Metrics()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), m.MergeDuration.Count)
+	assert.Greater(t, m.MergeReclaimedBytes, int64(0))
+	// 回收量为负数的 merge 不会减少累计值
+	db.onMergeEnd(MergeEndInfo{ReclaimedBytes: -1 << 30})
+	m2, err := db.Metrics()
+	assert.Nil(t, err)
+	assert.Equal(t, m.MergeReclaimedBytes, m2.MergeReclaimedBytes)
+
+	// 以文本格式输出
+	recorder := httptest.NewRecorder()
+	MetricsHandler(db).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, "# TYPE bitcask_put_duration_seconds histogram\n")
+	assert.Contains(t, body, "bitcask_put_duration_seconds_bucket{le=\"+Inf\"} 1000\n")
+	assert.Contains(t, body, "bitcask_put_duration_seconds_count 1000\n")
+	assert.Contains(t, body, "bitcask_batch_commit_records_sum 10\n")
+	assert.Contains(t, body, "# TYPE bitcask_written_bytes_total counter\n")
+	assert.Contains(t, body, "bitcask_keys 510\n")
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			assert.Equal(t, 2, len(strings.Fields(line)), line)
+		}
+	}
+
+	// 关闭之后不能获取指标
+	assert.Nil(t, db.Close())
+	_, err = db.Metrics()
+	assert.Equal(t, ErrDatabaseClosed, err)
+	recorder = httptest.NewRecorder()
+	MetricsHandler(db).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
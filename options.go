@@ -1,17 +1,34 @@
 package bitcask_go
 
-import "os"
+import (
+	"bitcask-go/data"
+	"os"
+	"runtime"
+	"time"
+)
 
 type Options struct {
 	// 数据库数据目录
 	DirPath string
 
+	// 以只读方式打开数据目录，可以和正在写入的实例以及其他只读实例同时打开同一个目录
+	// 只读实例不加文件锁，也不会创建或修改目录中的任何文件，写操作返回 ErrReadOnly，通过 Refresh 加载之后写入的数据
+	// 只读模式下不使用 MMap 加载数据文件，也不支持 B+ 树索引
+	ReadOnly bool
+
 	// 数据文件大小（活跃文件写阈值）
 	DataFileSize int64
 
-	// 每次写入数据后是否进行持久化
+	// 每次写入数据后是否进行持久化，并发的同步写入会合并成一次持久化（group commit）
 	SyncWrites bool
 
+	// group commit 中负责持久化的写入者最多等待这么长时间收集其他写入者，为 0 表示不等待
+	// 不等待时，持久化期间到达的写入者也会合并到下一次持久化中
+	GroupCommitMaxDelay time.Duration
+
+	// 等待持久化的写入者达到这个数量时不再等待 GroupCommitMaxDelay，立即持久化，为 0 表示不限制
+	GroupCommitMaxBatch int
+
 	// 累计写到多少字节后进行持久化
 	BytesPerSync uint
 
@@ -21,8 +38,69 @@ type Options struct {
 	// 启动时是否使用 MMap 加载数据
 	MMapAtStartup bool
 
-	// 数据文件进行 merge 的阈值
+	// 启动时并行解析数据文件的协程数量，为 0 表示顺序加载
+	LoadConcurrency int
+
+	// 关闭数据库时是否保存内存索引的 checkpoint，下次启动时可以更快地加载索引
+	IndexCheckpointOnClose bool
+
+	// 启动时活跃文件尾部存在不完整或损坏的数据时的处理方式
+	RecoveryMode RecoveryMode
+
+	// value 的压缩方式，已经写入的数据不受影响，不同压缩方式的数据可以共存
+	// 没有提供 zstd：标准库没有 zstd 的实现，在仓库中实现或引入 zstd 的解码器代价过高，
+	// 因此使用标准库 compress/flate 的 DEFLATE 代替，作为压缩率更高、速度较慢的选项；snappy 在 data 包中实现
+	Compression CompressionType
+
+	// 小于这个长度的 value 不进行压缩，直接存储原始数据
+	CompressionThreshold int
+
+	// 加密数据使用的 AES 密钥，长度为 16、24 或 32 字节，为空表示不加密，密钥 id 为 0
+	// 数据文件、hint 文件、事务序列号、merge 完成标识和 index checkpoint 中的记录都会加密
+	EncryptionKey []byte
+
+	// 提供加密密钥，支持密钥轮换，和 EncryptionKey 只能设置一个
+	KeyProvider KeyProvider
+
+	// 新写入记录使用的校验算法，读取时根据每条记录中的标识选择，不同校验算法的数据可以共存
+	Checksum ChecksumType
+
+	// 不小于这个长度的 value 单独存储在 blob 文件中，数据文件中只存储 blob 的位置，为 0 表示不开启
+	// merge 时不需要重写这些 value，只会重写无效数据比例较高的 blob 文件
+	BlobThreshold int
+
+	// blob 文件进行回收的阈值，即文件中无效数据的占比，达到阈值的 blob 文件在 merge 时重写其中的有效数据后删除
+	// 为 0 表示不重写 blob 文件，只删除没有有效数据的 blob 文件
+	BlobGCRatio float32
+
+	// 数据文件进行 merge 的阈值，blob 文件不计算在内
 	DataFileMergeRatio float32
+
+	// 单个旧数据文件进行 compaction 的阈值，即文件中无效数据的占比
+	DataFileCompactRatio float32
+
+	// 一次 compaction 最多处理的文件数量，为 0 表示不限制
+	CompactMaxFiles int
+
+	// 后台自动 merge 的检查间隔，为 0 表示不开启自动 merge
+	AutoMergeInterval time.Duration
+
+	// 允许自动 merge 的时间窗口，表示一天中从零点开始的时间偏移，左闭右开
+	// 开始时间大于结束时间表示跨越零点，两者相等表示不限制
+	AutoMergeWindowStart time.Duration
+	AutoMergeWindowEnd   time.Duration
+
+	// 同一进程中允许同时进行自动 merge 的数据库数量，限制 merge 带来的磁盘 IO，为 0 表示不限制
+	AutoMergeMaxConcurrency int
+
+	// 输出日志使用的 Logger，可以直接使用 *slog.Logger，为空表示不输出日志
+	Logger Logger
+
+	// 接收文件转换、merge、启动恢复和持久化较慢等事件的回调，为空表示不接收
+	EventListener EventListener
+
+	// 一次持久化的耗时达到这个阈值时通知 EventListener.OnSyncSlow，为 0 表示不检查
+	SlowSyncThreshold time.Duration
 }
 
 type IteratorOptions struct {
@@ -41,6 +119,12 @@ type WriteBatchOptions struct {
 	SyncWrites bool
 }
 
+// TxnOptions 交互式事务配置项
+type TxnOptions struct {
+	// 提交时是否 sync 持久化，数据库配置了 SyncWrites 时总是持久化
+	SyncWrites bool
+}
+
 type IndexerType = int8
 
 const (
@@ -54,14 +138,68 @@ const (
 	BPlusTreeIndex
 )
 
+type RecoveryMode = int8
+
+const (
+	// RecoveryTruncate 将活跃文件截断到最后一条完整记录的位置
+	RecoveryTruncate RecoveryMode = iota
+
+	// RecoveryStrict 不做任何处理，打开数据库失败
+	RecoveryStrict
+
+	// RecoverySkip 保留损坏的数据不做修改，之后的数据写入新的活跃文件
+	RecoverySkip
+)
+
+// KeyProvider 提供加密数据使用的密钥，切换当前密钥 id 之后新写入的数据使用新的密钥，merge 时旧的数据会使用新的密钥重新加密
+type KeyProvider = data.KeyProvider
+
+type CompressionType = byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+
+	// SnappyCompression snappy 格式的 LZ 压缩，速度快，压缩率一般
+	SnappyCompression
+
+	// DeflateCompression DEFLATE 压缩，速度较慢，压缩率更高，代替 zstd 使用
+	DeflateCompression
+)
+
+type ChecksumType = byte
+
+const (
+	// ChecksumCRC32 CRC32（IEEE 多项式），旧版本的数据都使用这种校验方式
+	ChecksumCRC32 ChecksumType = iota
+
+	// ChecksumCRC32C CRC32C（Castagnoli 多项式），支持硬件加速，速度最快
+	ChecksumCRC32C
+
+	// ChecksumXXHash64 64 位的 xxHash，更不容易发生碰撞，每条记录多占用 4 个字节
+	ChecksumXXHash64
+)
+
 var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024, // 256 MB
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexerType:        BTreeIndex,
-	MMapAtStartup:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:                os.TempDir(),
+	DataFileSize:           256 * 1024 * 1024, // 256 MB
+	SyncWrites:             false,
+	GroupCommitMaxDelay:    0,
+	GroupCommitMaxBatch:    64,
+	BytesPerSync:           0,
+	IndexerType:            BTreeIndex,
+	MMapAtStartup:          true,
+	LoadConcurrency:        runtime.NumCPU(),
+	IndexCheckpointOnClose: false,
+	RecoveryMode:           RecoveryTruncate,
+	Compression:            NoCompression,
+	CompressionThreshold:   256,
+	BlobGCRatio:            0.5,
+	DataFileMergeRatio:     0.5,
+	DataFileCompactRatio:   0.5,
+	CompactMaxFiles:        4,
+	AutoMergeInterval:      0,
+	SlowSyncThreshold:      time.Second,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -73,3 +211,7 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultTxnOptions = TxnOptions{
+	SyncWrites: true,
+}
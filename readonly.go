@@ -0,0 +1,215 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"path/filepath"
+)
+
+// readOnlyLoadRetries 只读模式下全部加载失败时的重试次数
+// 写实例进行 merge 或 compaction 时会删除数据文件，加载期间可能找不到 MANIFEST 中记录的文件
+const readOnlyLoadRetries = 3
+
+// Refresh 只读模式下加载写实例在打开之后写入的数据，写实例调用时直接返回
+// 通常只需要打开新建的数据文件，从上次读到的位置继续加载，写实例进行了 merge 时会重新加载全部数据文件和索引
+// 已经创建的快照和迭代器不受影响，它们引用的文件在释放之前依然可以读取
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	// merge 完成标识被替换过，说明数据文件已经被 merge 生成的文件替换了
+	mark, err := statMergeFinished(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if !db.needReload && sameFile(db.mergeMark, mark) {
+		db.needReload = true
+		if err := db.refreshFiles(); err == nil {
+			db.needReload = false
+			return nil
+		}
+	}
+	return db.reload()
+}
+
+// reload 重新加载全部数据文件和索引，失败时重试，需要持有互斥锁
+func (db *DB) reload() error {
+	var err error
+	for i := 0; i < readOnlyLoadRetries; i++ {
+		if err = db.reloadFiles(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// reloadFiles 关闭当前所有的文件并清空索引，然后重新加载全部数据文件和索引，需要持有互斥锁
+// 加载期间同一个文件 id 可能对应新旧两个文件，不持有锁的读操作改为持有锁读取
+func (db *DB) reloadFiles() error {
+	db.fileSwaps.Add(1)
+	defer func() {
+		db.publishFiles()
+		db.fileSwaps.Add(1)
+	}()
+	// 加载失败时索引和文件都不完整，下一次 Refresh 需要重新加载
+	db.needReload = true
+
+	// 先记录 merge 完成标识，加载期间发生的 merge 在下一次 Refresh 时处理
+	mark, err := statMergeFinished(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	manifest, err := readManifest(db.options.DirPath)
+	if err == nil && manifest != nil {
+		err = checkManifest(manifest, db.options)
+	}
+	if err != nil {
+		return err
+	}
+
+	// 关闭当前的文件，仍被快照或迭代器引用的文件在引用释放后关闭
+	for _, fileMap := range []map[uint32]*data.DataFile{db.olderFiles, db.blobFiles} {
+		for _, file := range fileMap {
+			if err := db.retireDataFile(file); err != nil {
+				return err
+			}
+		}
+	}
+	if db.activeFile != nil {
+		if err := db.retireDataFile(db.activeFile); err != nil {
+			return err
+		}
+	}
+	db.activeFile, db.activeBlobFile = nil, nil
+	db.olderFiles = make(map[uint32]*data.DataFile)
+	db.blobFiles = make(map[uint32]*data.DataFile)
+	db.fileIds = nil
+	db.manifest = manifest
+
+	// 清空索引和统计信息，索引需要原地修改，不持有锁的操作可能正在使用它
+	var keys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	iterator.Close()
+	for _, key := range keys {
+		db.index.Delete(key)
+	}
+	db.reclaimSize = 0
+	db.fileDeadBytes = make(map[uint32]int64)
+	db.pendingTxns = nil
+
+	if err := db.loadDataFiles(); err != nil {
+		return err
+	}
+	if err := db.loadIndex(); err != nil {
+		return err
+	}
+	db.mergeMark, db.needReload = mark, false
+	return nil
+}
+
+// refreshFiles 打开写实例新建的数据文件和 blob 文件，从上次读到的位置继续加载索引，需要持有互斥锁
+// 加载完成后关闭已经被 compaction 删除的文件，这些文件中的有效数据已经重写到了更新的文件中
+func (db *DB) refreshFiles() error {
+	manifest, err := readManifest(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	db.manifest = manifest
+	fileIds, err := db.listDataFileIds()
+	if err != nil {
+		return err
+	}
+
+	// 从上次读到的位置继续加载
+	var startFileId uint32
+	var startOffset int64
+	if db.activeFile != nil {
+		startFileId, startOffset = db.activeFile.FileId, db.activeFile.WriteOff
+	}
+	listed := make(map[uint32]struct{}, len(fileIds))
+	for _, fid := range fileIds {
+		listed[uint32(fid)] = struct{}{}
+		if db.activeFile != nil && uint32(fid) <= db.activeFile.FileId {
+			// 更早的文件都已经打开过了，否则数据文件被替换过，需要重新加载
+			if !db.dataFileExist(uint32(fid)) {
+				return ErrDataFileNotFound
+			}
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), fio.ReadOnlyFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Codec = db.codec
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+	}
+	db.fileIds = fileIds
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+	// 索引指向新的文件之前先发布文件
+	db.publishFiles()
+
+	if err := db.loadIndexFromDataFiles(startFileId, startOffset); err != nil {
+		return err
+	}
+
+	for fid, dataFile := range db.olderFiles {
+		if _, ok := listed[fid]; ok {
+			continue
+		}
+		delete(db.olderFiles, fid)
+		delete(db.fileDeadBytes, fid)
+		if err := db.retireDataFile(dataFile); err != nil {
+			return err
+		}
+	}
+	if db.manifest != nil {
+		listedBlobs := make(map[uint32]struct{}, len(db.manifest.blobFileIds))
+		for _, fid := range db.manifest.blobFileIds {
+			listedBlobs[fid] = struct{}{}
+		}
+		for fid, blobFile := range db.blobFiles {
+			if _, ok := listedBlobs[fid]; ok {
+				continue
+			}
+			delete(db.blobFiles, fid)
+			if err := db.retireDataFile(blobFile); err != nil {
+				return err
+			}
+		}
+	}
+	db.publishFiles()
+	return nil
+}
+
+// statMergeFinished 获取数据目录中 merge 完成标识的文件信息，不存在时返回 nil
+// 每次 merge 都会链接一个新的标识文件，用于判断数据文件是否被替换过
+func statMergeFinished(dirPath string) (os.FileInfo, error) {
+	info, err := os.Stat(filepath.Join(dirPath, data.MergeFinishedFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return info, err
+}
+
+// sameFile 判断两个文件信息是否对应同一个文件，都为空时也视为相同
+func sameFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b)
+}
@@ -0,0 +1,157 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// listDir 数据目录中的文件名，不包括锁文件
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != fileLockName {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BlobThreshold = 512
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	// 目录不存在时不会创建
+	roOpts.DirPath = filepath.Join(dir, "not-exist")
+	_, err := Open(roOpts)
+	assert.True(t, os.IsNotExist(err))
+	roOpts.DirPath = dir
+	// 只读模式不支持 B+ 树索引
+	bptOpts := roOpts
+	bptOpts.IndexerType = BPlusTreeIndex
+	_, err = Open(bptOpts)
+	assert.NotNil(t, err)
+
+	// 空目录中不会创建活跃文件，只读实例不加锁，也不会创建锁文件
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	_, err = ro.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+	assert.Nil(t, ro.Close())
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	blob := utils.RandomValue(1024)
+	assert.Nil(t, db.Put([]byte("blob"), blob))
+
+	// 写实例运行期间可以同时打开多个只读实例
+	ro, err = Open(roOpts)
+	assert.Nil(t, err)
+	ro2, err := Open(roOpts)
+	assert.Nil(t, err)
+	files := listDir(t, dir)
+	for _, r := range []*DB{ro, ro2} {
+		val, err := r.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(10), val)
+		assert.Equal(t, 1001, len(r.ListKeys()))
+	}
+	assert.Nil(t, ro2.Close())
+
+	// 写操作都会被拒绝
+	assert.Equal(t, ErrReadOnly, ro.Put(utils.GetTestKey(1), []byte("new")))
+	assert.Equal(t, ErrReadOnly, ro.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, ro.Merge())
+	assert.Equal(t, ErrReadOnly, ro.Compact())
+	wb := ro.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("new")))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	txn, err := ro.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1), []byte("new")))
+	assert.Equal(t, ErrReadOnly, txn.Commit())
+	assert.Equal(t, 0, ro.activeTxns)
+
+	// 加载写实例之后写入的数据，包括新建的数据文件
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	_, err = ro.Get(utils.GetTestKey(1500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, 1901, len(ro.ListKeys()))
+	val, err := ro.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1500), val)
+	_, err = ro.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 写实例进行 merge 之后重新加载，之前的迭代器依然可以读取
+	iter := ro.NewIterator(DefaultIteratorOptions)
+	for i := 100; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("merged")))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("value")))
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, 1902, len(ro.ListKeys()))
+	val, err = ro.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("merged"), val)
+	val, err = ro.Get([]byte("blob"))
+	assert.Nil(t, err)
+	assert.Equal(t, blob, val)
+	_, err = ro.Get([]byte("after-merge"))
+	assert.Nil(t, err)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 1901, count)
+	iter.Close()
+
+	// compaction 删除的文件在 Refresh 之后关闭
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("compacted")))
+	}
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, len(db.olderFiles), len(ro.olderFiles))
+	val, err = ro.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("compacted"), val)
+	assert.Equal(t, 0, len(ro.obsoleteFiles))
+
+	// 只读实例关闭时不会修改数据目录
+	files = listDir(t, dir)
+	assert.Nil(t, ro.Close())
+	assert.Equal(t, files, listDir(t, dir))
+
+	// 写实例的 Refresh 直接返回
+	assert.Nil(t, db.Refresh())
+}
@@ -0,0 +1,79 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"os"
+)
+
+// RecoveryReport 启动时活跃文件尾部不完整或损坏数据的处理情况
+type RecoveryReport struct {
+	Mode           RecoveryMode // 处理方式
+	FileId         uint32       // 尾部损坏的数据文件 id
+	ValidOffset    int64        // 最后一条完整记录的结束位置
+	DiscardedBytes int64        // 被丢弃的数据大小
+	Reason         string       // 损坏的原因，为空表示尾部的数据写了一半
+}
+
+// recoverActiveFile 处理活跃文件尾部不完整或损坏的数据，需要在加载索引时调用
+// validOffset 是最后一条完整记录的结束位置，cause 是读取之后的数据时遇到的错误
+func (db *DB) recoverActiveFile(validOffset int64, cause error) error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if validOffset >= size {
+		return nil
+	}
+
+	report := &RecoveryReport{
+		Mode:           db.options.RecoveryMode,
+		FileId:         db.activeFile.FileId,
+		ValidOffset:    validOffset,
+		DiscardedBytes: size - validOffset,
+	}
+	if cause != nil {
+		report.Reason = cause.Error()
+	}
+
+	switch db.options.RecoveryMode {
+	case RecoveryStrict:
+		return ErrActiveFileCorrupted
+	case RecoverySkip:
+		// 损坏的文件作为旧文件保留，hint 文件中只有完整的记录，之后的数据写入新的活跃文件
+		// 在最后一条完整记录之后写入结束标识，merge 和 compaction 读到这里就会停止，不会读到损坏的数据
+		if err := db.sealActiveFileAt(validOffset); err != nil {
+			return err
+		}
+		if err := db.retireActiveFile(db.activeFile.FileId + 1); err != nil {
+			return err
+		}
+	default:
+		// 截断到最后一条完整记录的位置，之后的数据从这里继续写入
+		fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+		if err := os.Truncate(fileName, validOffset); err != nil {
+			return err
+		}
+	}
+	db.recoveryReport = report
+	return nil
+}
+
+// sealActiveFileAt 在活跃文件的 offset 处写入结束标识并持久化，覆盖损坏数据的开头，其余的数据保留在文件中
+func (db *DB) sealActiveFileAt(offset int64) error {
+	fileName := data.GetDataFileName(db.options.DirPath, db.activeFile.FileId)
+	file, err := os.OpenFile(fileName, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	endOfFile := db.codec.EncodeEndOfFile()
+	if _, err := file.WriteAt(endOfFile, offset); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	db.activeFile.WriteOff = offset + int64(len(endOfFile))
+	return file.Close()
+}
@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// prepareTornDB 写入数据后在活跃文件的末尾追加一段不完整的数据
+func prepareTornDB(t *testing.T, tail []byte) (Options, uint32, int64) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	fileId, validOffset := db.activeFile.FileId, db.activeFile.WriteOff
+	assert.Nil(t, db.Close())
+
+	file, err := os.OpenFile(data.GetDataFileName(dir, fileId), os.O_WRONLY|os.O_APPEND, 0)
+	assert.Nil(t, err)
+	_, err = file.Write(tail)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	return opts, fileId, validOffset
+}
+
+func TestDB_Recovery_Truncate(t *testing.T) {
+	// 写了一半的记录，以及写入了错误数据的记录
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("key"), Value: []byte("value")})
+	corrupted := append([]byte{}, encRecord...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	// 非零的垃圾数据，header 中的变长字段无法解码
+	garbage := bytes.Repeat([]byte{0xff}, 40)
+	tails := [][]byte{encRecord[:len(encRecord)-3], corrupted, make([]byte, 32), garbage}
+
+	for _, tail := range tails {
+		opts, fileId, validOffset := prepareTornDB(t, tail)
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		report := mustStat(t, db).Recovery
+		assert.NotNil(t, report)
+		assert.Equal(t, fileId, report.FileId)
+		assert.Equal(t, validOffset, report.ValidOffset)
+		assert.Equal(t, int64(len(tail)), report.DiscardedBytes)
+		assert.Equal(t, validOffset, db.activeFile.WriteOff)
+		stat, err := os.Stat(data.GetDataFileName(opts.DirPath, fileId))
+		assert.Nil(t, err)
+		assert.Equal(t, validOffset, stat.Size())
+
+		// 截断之后可以继续正常写入
+		assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+		assert.Nil(t, db.Close())
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Nil(t, mustStat(t, db2).Recovery)
+		assert.Equal(t, 1001, len(db2.ListKeys()))
+		destroyDB(db2)
+	}
+}
+
+func TestDB_Recovery_Strict(t *testing.T) {
+	opts, _, _ := prepareTornDB(t, []byte("torn"))
+	defer os.RemoveAll(opts.DirPath)
+	opts.RecoveryMode = RecoveryStrict
+	_, err := Open(opts)
+	assert.Equal(t, ErrActiveFileCorrupted, err)
+}
+
+func TestDB_Recovery_Skip(t *testing.T) {
+	// 校验值错误的记录
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: []byte("key"), Value: []byte("value")})
+	encRecord[len(encRecord)-1] ^= 0xff
+	opts, fileId, validOffset := prepareTornDB(t, encRecord)
+	opts.RecoveryMode = RecoverySkip
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, mustStat(t, db).Recovery)
+
+	// 损坏的文件在最后一条完整记录之后写入了结束标识，之后写入新的活跃文件
+	assert.True(t, db.olderFiles[fileId].IsEndOfFile(validOffset))
+	assert.Equal(t, fileId+1, db.activeFile.FileId)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+
+	// 即使没有 hint 文件，也可以跳过损坏的数据
+	assert.Nil(t, removeHintFile(opts.DirPath, fileId))
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, mustStat(t, db2).Recovery)
+	assert.Equal(t, 1001, len(db2.ListKeys()))
+
+	// merge 不会读到损坏的数据
+	db2.options.DataFileMergeRatio = 0
+	assert.Nil(t, db2.Merge())
+	assert.Nil(t, db2.Close())
+	db2, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db2.ListKeys()))
+	for i := 0; i < 1000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	destroyDB(db2)
+}
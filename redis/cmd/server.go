@@ -5,10 +5,14 @@ import (
 	bitcask_redis "bitcask-go/redis"
 	"github.com/tidwall/redcon"
 	"log"
+	"net/http"
 	"sync"
 )
 
-const addr = "127.0.0.1:6380"
+const (
+	addr        = "127.0.0.1:6380"
+	metricsAddr = "127.0.0.1:6381" // 输出存储引擎指标的 HTTP 地址
+)
 
 type BitcaskServer struct {
 	dbs    map[int]*bitcask_redis.RedisDataStructure
@@ -17,8 +21,10 @@ type BitcaskServer struct {
 }
 
 func main() {
-	// 打开 Redis 数据结构服务
-	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(bitcask.DefaultOptions)
+	// 打开 Redis 数据结构服务，引擎的日志和服务的日志一起输出
+	options := bitcask.DefaultOptions
+	options.Logger = bitcask.NewStdLogger(log.Default(), bitcask.LogLevelInfo)
+	redisDataStructure, err := bitcask_redis.NewRedisDataStructure(options)
 	if err != nil {
 		panic(err)
 	}
@@ -29,6 +35,15 @@ func main() {
 	}
 	bitcaskServer.dbs[0] = redisDataStructure
 
+	// 在单独的 HTTP 端口上输出存储引擎指标
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", redisDataStructure.MetricsHandler())
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			log.Printf("metrics server stopped: %v", err)
+		}
+	}()
+
 	// 初始化一个 Redis 服务端
 	bitcaskServer.server = redcon.NewServer(addr, execClientCommand, bitcaskServer.accept, bitcaskServer.close)
 	bitcaskServer.listen() // 监听端口
@@ -5,6 +5,9 @@ import (
 	"bitcask-go/utils"
 	"encoding/binary"
 	"errors"
+	"hash/fnv"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -22,9 +25,13 @@ const (
 	ZSet
 )
 
+// keyLockShards 串行执行同一个 key 的读改写时使用的锁的数量
+const keyLockShards = 64
+
 // RedisDataStructure Redis 数据结构服务
 type RedisDataStructure struct {
-	db *bitcask.DB
+	db       *bitcask.DB
+	keyLocks [keyLockShards]sync.Mutex // 按照 key 的哈希值分片，同一个 key 的事务串行执行
 }
 
 // NewRedisDataStructure 初始化 Redis 数据结构服务
@@ -40,6 +47,11 @@ func (rds *RedisDataStructure) Close() error {
 	return rds.db.Close()
 }
 
+// MetricsHandler 返回以 Prometheus 文本格式输出存储引擎指标的 http.Handler
+func (rds *RedisDataStructure) MetricsHandler() http.Handler {
+	return bitcask.MetricsHandler(rds.db)
+}
+
 // ==================== String 数据结构 ====================
 
 func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte) error {
@@ -89,35 +101,38 @@ func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
 // ==================== Hash 数据结构 ====================
 
 // HSet 返回操作结果和错误，只有当数据设置之前不存在才返回 true
+// 元数据的读取和更新在同一个事务中完成，避免并发写入时数据个数统计错误
 func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, Hash)
-	if err != nil {
-		return false, err
-	}
+	var exist bool
+	err := rds.update(key, func(txn *bitcask.Txn) error {
+		// 查找元数据
+		meta, err := rds.findMetadataBy(txn.Get, key, Hash)
+		if err != nil {
+			return err
+		}
 
-	// 构造 Hash 数据部分的 key
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
-	}
-	encKey := hk.encode()
+		// 构造 Hash 数据部分的 key
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
 
-	// 根据数据 key 去查找是否存在
-	var exist = true
-	if _, err := rds.db.Get(encKey); err == bitcask.ErrKeyNotFound {
-		exist = false
-	}
+		// 根据数据 key 去查找是否存在
+		exist = true
+		if _, err := txn.Get(encKey); err == bitcask.ErrKeyNotFound {
+			exist = false
+		}
 
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	// 不存在则更新元数据
-	if !exist {
-		meta.size++ // 数据个数 + 1
-		_ = wb.Put(key, meta.encode())
-	}
-	_ = wb.Put(encKey, value)
-	if err = wb.Commit(); err != nil {
+		// 不存在则更新元数据
+		if !exist {
+			meta.size++ // 数据个数 + 1
+			_ = txn.Put(key, meta.encode())
+		}
+		return txn.Put(encKey, value)
+	})
+	if err != nil {
 		return false, err
 	}
 	return !exist, nil
@@ -363,53 +378,56 @@ func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error)
 
 // ==================== ZSet 数据结构 ====================
 
+// ZAdd 元数据和数据的读取、更新在同一个事务中完成
 func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, ZSet)
-	if err != nil {
-		return false, err
-	}
-
-	// 构造数据部分的key
-	zk := &zsetInternalKey{
-		key:     key,
-		version: meta.version,
-		score:   score,
-		member:  member,
-	}
-
-	var exist = true
-	// 查看是否已经存在
-	value, err := rds.db.Get(zk.encodeWithMember())
-	if err != nil && err != bitcask.ErrKeyNotFound {
-		return false, err
-	}
-	if err == bitcask.ErrKeyNotFound {
-		exist = false
-	}
-	if exist {
-		if score == utils.FloatFromBytes(value) {
-			return false, nil
+	var exist bool
+	err := rds.update(key, func(txn *bitcask.Txn) error {
+		meta, err := rds.findMetadataBy(txn.Get, key, ZSet)
+		if err != nil {
+			return err
 		}
-	}
 
-	// 更新元数据和数据
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
-	if exist {
-		oldKey := &zsetInternalKey{
+		// 构造数据部分的key
+		zk := &zsetInternalKey{
 			key:     key,
 			version: meta.version,
+			score:   score,
 			member:  member,
-			score:   utils.FloatFromBytes(value),
 		}
-		_ = wb.Delete(oldKey.encodeWithScore())
-	}
-	_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = wb.Put(zk.encodeWithScore(), nil)
-	if err = wb.Commit(); err != nil {
+
+		exist = true
+		// 查看是否已经存在
+		value, err := txn.Get(zk.encodeWithMember())
+		if err != nil && err != bitcask.ErrKeyNotFound {
+			return err
+		}
+		if err == bitcask.ErrKeyNotFound {
+			exist = false
+		}
+		if exist {
+			if score == utils.FloatFromBytes(value) {
+				return nil
+			}
+		}
+
+		// 更新元数据和数据
+		if !exist {
+			meta.size++
+			_ = txn.Put(key, meta.encode())
+		}
+		if exist {
+			oldKey := &zsetInternalKey{
+				key:     key,
+				version: meta.version,
+				member:  member,
+				score:   utils.FloatFromBytes(value),
+			}
+			_ = txn.Delete(oldKey.encodeWithScore())
+		}
+		_ = txn.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
+		return txn.Put(zk.encodeWithScore(), nil)
+	})
+	if err != nil {
 		return false, err
 	}
 
@@ -441,8 +459,43 @@ func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error
 }
 
 // ==================== 通用方法 ====================
+
+// maxTxnRetries 事务冲突时的最大重试次数
+const maxTxnRetries = 16
+
+// update 在事务中执行对 key 的读改写 fn 并同步提交，发生冲突时重试
+// 同步提交需要等待持久化，同一个 key 的并发事务几乎总是冲突，因此在进程内先按 key 串行执行，冲突只来自其他写入
+func (rds *RedisDataStructure) update(key []byte, fn func(txn *bitcask.Txn) error) error {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	keyLock := &rds.keyLocks[h.Sum32()%keyLockShards]
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	var err error
+	for i := 0; i < maxTxnRetries; i++ {
+		var txn *bitcask.Txn
+		if txn, err = rds.db.Begin(bitcask.DefaultTxnOptions); err != nil {
+			return err
+		}
+		if err = fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Commit(); err != bitcask.ErrTxnConflict {
+			return err
+		}
+	}
+	return err
+}
+
 func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
-	metaBuf, err := rds.db.Get(key)
+	return rds.findMetadataBy(rds.db.Get, key, dataType)
+}
+
+// findMetadataBy 使用指定的读取方法查找元数据，可以在事务中读取
+func (rds *RedisDataStructure) findMetadataBy(get func(key []byte) ([]byte, error), key []byte, dataType redisDataType) (*metadata, error) {
+	metaBuf, err := get(key)
 	if err != nil && err != bitcask.ErrKeyNotFound {
 		return nil, err
 	}
@@ -5,6 +5,7 @@ import (
 	"bitcask-go/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -262,3 +263,28 @@ func TestRedisDataStructure_ZScore(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, float64(98), score)
 }
+
+func TestRedisDataStructure_HSet_Concurrent(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-hset-concurrent")
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := rds.HSet(utils.GetTestKey(1), utils.GetTestKey(n*100+j), []byte("value"))
+				assert.Nil(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	meta, err := rds.findMetadata(utils.GetTestKey(1), Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(400), meta.size)
+}
@@ -0,0 +1,104 @@
+package bitcask_go
+
+import (
+	"bitcask-go/index"
+	"sync"
+	"time"
+)
+
+// Snapshot 数据库在某个序列号时刻的一致性只读视图
+// 快照持有创建时内存索引的拷贝，并引用当时所有的数据文件，在 Release 之前这些文件不会被删除
+// B+ 树索引不拷贝整个索引，只保存快照创建之后被修改的 key 的旧位置
+type Snapshot struct {
+	db       *DB
+	mu       *sync.RWMutex
+	seqNo    uint64        // 创建快照时的事务序列号
+	index    index.Indexer // 创建快照时的内存索引拷贝
+	files    *fileSet      // 快照引用的数据文件和 blob 文件
+	released bool
+}
+
+// NewSnapshot 创建数据库当前时刻的快照，使用完毕后需要调用 Release 释放
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	// 持有互斥锁，保证批量写入的索引更新不会只有一部分被快照看到
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	indexer, err := db.index.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		db:    db,
+		mu:    new(sync.RWMutex),
+		seqNo: db.seqNo,
+		index: indexer,
+		files: db.pinDataFiles(),
+	}, nil
+}
+
+// SeqNo 返回快照对应的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 读取快照创建时刻 key 对应的 value
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if s.db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return getPinnedValue(s.files, logRecordPos)
+}
+
+// NewIterator 创建遍历快照数据的迭代器，迭代器需要在快照释放之前关闭
+// 快照释放之后返回的迭代器中没有数据
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return &Iterator{
+			indexIter: index.NewBTree().Iterator(opts.Reverse),
+			db:        s.db,
+			options:   opts,
+			files:     &fileSet{},
+		}
+	}
+	return &Iterator{
+		indexIter: s.index.Iterator(opts.Reverse),
+		db:        s.db,
+		options:   opts,
+		files:     s.files,
+	}
+}
+
+// Release 释放快照，解除对数据文件的引用
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.db.unpinDataFiles(s.files)
+	_ = s.index.Close()
+	s.index = nil
+}
@@ -0,0 +1,148 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	snap, err := db.NewSnapshot()
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.olderFiles)+1, pinnedFileCount(db))
+
+	// 创建快照之后的修改对快照不可见
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 50; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value"))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(200), []byte("new value"))
+	assert.Nil(t, err)
+
+	val, err := snap.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), val)
+	val, err = snap.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(60), val)
+	_, err = snap.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), value)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	// 数据库本身能看到最新的数据
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 释放快照
+	snap.Release()
+	assert.Equal(t, 0, pinnedFileCount(db))
+	_, err = snap.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrSnapshotReleased, err)
+
+	// 释放之后创建的迭代器中没有数据
+	iter = snap.NewIterator(DefaultIteratorOptions)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Seek(utils.GetTestKey(10))
+	assert.False(t, iter.Valid())
+	iter.Close()
+	assert.Equal(t, 0, pinnedFileCount(db))
+}
+
+func TestDB_NewSnapshot_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-bptree")
+	opts.DirPath = dir
+	opts.IndexerType = BPlusTreeIndex
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+
+	// 快照只保存被修改的 key 的旧位置
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value")))
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(200), []byte("new value")))
+
+	val, err := snap.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), val)
+	_, err = snap.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), value)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	snap.Release()
+	val, err = db.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+}
+
+// pinnedFileCount 被引用的数据文件和 blob 文件的数量，包括已经被删除的文件
+func pinnedFileCount(db *DB) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var count int
+	files := db.files.Load()
+	for _, fileMap := range []map[uint32]*data.DataFile{files.dataFiles, files.blobFiles} {
+		for _, file := range fileMap {
+			if file.Refs() > 0 {
+				count++
+			}
+		}
+	}
+	for file := range db.obsoleteFiles {
+		if file.Refs() > 0 {
+			count++
+		}
+	}
+	return count
+}
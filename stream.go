@@ -0,0 +1,289 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"time"
+)
+
+// blobChunkSize 流式写入时每个分块中 value 的长度，读写时内存中最多只保留一个分块
+const blobChunkSize = 1 << 20
+
+// PutReader 从 r 中读取 size 字节作为 key 对应的 value 写入
+// 超过一个分块的 value 边读取边分块写入 blob 文件，不需要把整个 value 放在内存中，不受 BlobThreshold 的影响
+// 分块写入期间不持有互斥锁，其他的读写操作可以同时进行，全部分块写完之后才写入数据文件中的记录并更新索引
+// r 中的数据不足 size 字节时返回 io.ErrUnexpectedEOF，已经写入的分块成为无效数据，在 merge 时回收
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if size < 0 {
+		return ErrInvalidValueSize
+	}
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	// 较小的 value 直接读出后写入
+	if size <= blobChunkSize {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return db.Put(key, value)
+	}
+
+	logRecordKey := logRecordKeyWithSeq(key, nonTransactionSeqNo)
+	ref := &data.BlobRef{ChunkSize: blobChunkSize, ValueSize: size}
+	// 写入期间引用的 blob 文件不能被 merge 回收，写入结束后释放
+	defer db.releaseStreamingBlobs(ref)
+
+	buf := make([]byte, blobChunkSize)
+	for written := int64(0); written < size; {
+		chunk := buf
+		if size-written < blobChunkSize {
+			chunk = buf[:size-written]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := db.appendBlobChunk(ref, &data.LogRecord{Key: logRecordKey, Value: chunk}); err != nil {
+			return err
+		}
+		written += int64(len(chunk))
+	}
+
+	return db.update(db.options.SyncWrites, func() error {
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:         logRecordKey,
+			Value:       data.EncodeBlobRef(ref),
+			Type:        data.LogRecordNormal,
+			Compression: data.CompressionBlobRef,
+		})
+		if err != nil {
+			return err
+		}
+
+		db.trackTxnWrite(key, db.seqNo)
+		db.putIndex(key, pos)
+		return nil
+	})
+}
+
+// appendBlobChunk 压缩并写入一个分块，记录分块所在的 blob 文件
+func (db *DB) appendBlobChunk(ref *data.BlobRef, chunkRecord *data.LogRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+	chunkRecord, err := db.compressLogRecord(chunkRecord)
+	if err != nil {
+		return err
+	}
+	blobPos, err := db.appendBlobRecord(chunkRecord)
+	if err != nil {
+		return err
+	}
+	if n := len(ref.Chunks); n == 0 || ref.Chunks[n-1].Fid != blobPos.Fid {
+		db.streamingBlobs[blobPos.Fid]++
+	}
+	ref.Chunks = append(ref.Chunks, blobPos)
+	return nil
+}
+
+// releaseStreamingBlobs 流式写入结束，释放对分块所在 blob 文件的引用
+func (db *DB) releaseStreamingBlobs(ref *data.BlobRef) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for i, blobPos := range ref.Chunks {
+		if i > 0 && ref.Chunks[i-1].Fid == blobPos.Fid {
+			continue
+		}
+		if db.streamingBlobs[blobPos.Fid]--; db.streamingBlobs[blobPos.Fid] <= 0 {
+			delete(db.streamingBlobs, blobPos.Fid)
+		}
+	}
+}
+
+// GetReader 返回读取 key 对应 value 的 io.ReadCloser 以及 value 的长度，使用完毕后需要调用 Close
+// 分块存储的 value 按需逐块读取并校验，读到损坏的分块时返回 data.ErrInvalidCRC，其他的 value 直接读出
+// 读取期间引用的 blob 文件在 Close 之前不会被关闭，merge 回收之后依然可以读取
+func (db *DB) GetReader(key []byte) (io.ReadCloser, int64, error) {
+	value, reader, err := db.openValue(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if reader == nil {
+		return io.NopCloser(bytes.NewReader(value)), int64(len(value)), nil
+	}
+	return reader, reader.ref.ValueSize, nil
+}
+
+// GetRange 读取 key 对应的 value 从 offset 开始的 length 个字节
+// 超出 value 末尾的部分不返回，分块存储的 value 只读取范围内的分块
+func (db *DB) GetRange(key []byte, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, ErrInvalidRange
+	}
+	value, reader, err := db.openValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if reader == nil {
+		if offset >= int64(len(value)) {
+			return []byte{}, nil
+		}
+		if length > int64(len(value))-offset {
+			length = int64(len(value)) - offset
+		}
+		return value[offset : offset+length], nil
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+	if offset >= reader.ref.ValueSize {
+		return []byte{}, nil
+	}
+	end := reader.ref.ValueSize
+	if length < end-offset {
+		end = offset + length
+	}
+	reader.offset, reader.end = offset, end
+	buf := make([]byte, end-offset)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// openValue 查找 key 对应的 value，分块存储的 value 返回引用了所有分块所在 blob 文件的 blobReader，其他的 value 直接读出
+func (db *DB) openValue(key []byte) ([]byte, *blobReader, error) {
+	if len(key) == 0 {
+		return nil, nil, ErrKeyIsEmpty
+	}
+	// 持有读锁期间文件不会被删除，可以安全地增加引用计数
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return nil, nil, ErrDatabaseClosed
+	}
+
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || isExpired(logRecordPos, time.Now().UnixNano()) {
+		return nil, nil, ErrKeyNotFound
+	}
+	var dataFile *data.DataFile
+	if db.activeFile.FileId == logRecordPos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[logRecordPos.Fid]
+	}
+	if dataFile == nil {
+		return nil, nil, ErrDataFileNotFound
+	}
+	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, nil, ErrKeyNotFound
+	}
+	if logRecord.Compression != data.CompressionBlobRef {
+		value, err := data.DecompressValue(logRecord.Compression, logRecord.Value)
+		return value, nil, err
+	}
+
+	ref, err := data.DecodeBlobRef(logRecord.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ref.Chunked() {
+		value, err := readBlob(db.blobFiles, ref.Chunks[0])
+		return value, nil, err
+	}
+	files := &fileSet{blobFiles: make(map[uint32]*data.DataFile)}
+	for _, blobPos := range ref.Chunks {
+		blobFile := db.blobFiles[blobPos.Fid]
+		if blobFile == nil {
+			return nil, nil, ErrDataFileNotFound
+		}
+		files.blobFiles[blobPos.Fid] = blobFile
+	}
+	for _, blobFile := range files.blobFiles {
+		blobFile.Acquire()
+	}
+	return nil, &blobReader{db: db, files: files, ref: ref, end: ref.ValueSize}, nil
+}
+
+// blobReader 逐块读取分块存储的 value
+type blobReader struct {
+	db     *DB
+	files  *fileSet      // 分块所在的 blob 文件
+	ref    *data.BlobRef // 所有分块的位置
+	offset int64         // 下一次读取的位置
+	end    int64         // 读取结束的位置
+	chunk  []byte        // 当前分块中还没有读取的数据
+	closed bool
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	if br.closed {
+		return 0, ErrValueReaderClosed
+	}
+	if len(br.chunk) == 0 {
+		if br.offset >= br.end {
+			return 0, io.EOF
+		}
+		if err := br.loadChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.chunk)
+	br.chunk = br.chunk[n:]
+	br.offset += int64(n)
+	return n, nil
+}
+
+// loadChunk 读取 offset 所在的分块
+func (br *blobReader) loadChunk() error {
+	i := int(br.offset / br.ref.ChunkSize)
+	br.db.mu.RLock()
+	if br.db.closed.Load() {
+		br.db.mu.RUnlock()
+		return ErrDatabaseClosed
+	}
+	chunk, err := readBlob(br.files.blobFiles, br.ref.Chunks[i])
+	br.db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if int64(len(chunk)) != br.ref.ChunkLen(i) {
+		return data.ErrInvalidBlobRef
+	}
+	start := int64(i) * br.ref.ChunkSize
+	if br.end-start < int64(len(chunk)) {
+		chunk = chunk[:br.end-start]
+	}
+	br.chunk = chunk[br.offset-start:]
+	return nil
+}
+
+// Close 释放对 blob 文件的引用
+func (br *blobReader) Close() error {
+	if br.closed {
+		return nil
+	}
+	br.closed = true
+	br.chunk = nil
+	br.db.unpinDataFiles(br.files)
+	return nil
+}
@@ -0,0 +1,108 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDB_PutReader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Compression = SnappyCompression
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 不超过一个分块的 value 直接写入
+	small := utils.RandomValue(1024)
+	assert.Nil(t, db.PutReader([]byte("small"), bytes.NewReader(small), int64(len(small))))
+	val, err := db.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, small, val)
+
+	// 分块写入，分块跨越多个 blob 文件
+	large := utils.RandomValue(10 * 1024 * 1024)
+	assert.Nil(t, db.PutReader([]byte("large"), bytes.NewReader(large), int64(len(large))))
+	assert.True(t, len(db.blobFiles) > 1)
+	val, err = db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	// 数据不足
+	err = db.PutReader([]byte("short"), bytes.NewReader(large[:blobChunkSize*2]), blobChunkSize*3)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = db.Get([]byte("short"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrInvalidValueSize, db.PutReader([]byte("short"), bytes.NewReader(nil), -1))
+
+	reader, size, err := db.GetReader([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(large)), size)
+	buf, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, large, buf)
+	assert.Nil(t, reader.Close())
+	_, err = reader.Read(buf)
+	assert.Equal(t, ErrValueReaderClosed, err)
+
+	reader, size, err = db.GetReader([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(small)), size)
+	buf, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, small, buf)
+	assert.Nil(t, reader.Close())
+	_, _, err = db.GetReader([]byte("not exist"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 跨越分块边界的范围读取，以及超出末尾的部分
+	for _, r := range [][2]int64{{0, 10}, {blobChunkSize - 5, 10}, {blobChunkSize*3 - 1, blobChunkSize + 2}, {int64(len(large)) - 3, 10}} {
+		end := r[0] + r[1]
+		if end > int64(len(large)) {
+			end = int64(len(large))
+		}
+		val, err := db.GetRange([]byte("large"), r[0], r[1])
+		assert.Nil(t, err)
+		assert.Equal(t, large[r[0]:end], val)
+	}
+	val, err = db.GetRange([]byte("large"), int64(len(large)), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(val))
+	val, err = db.GetRange([]byte("small"), 1000, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, small[1000:], val)
+	_, err = db.GetRange([]byte("small"), -1, 100)
+	assert.Equal(t, ErrInvalidRange, err)
+
+	// 覆盖之后 merge 回收旧的分块，已经打开的 reader 依然可以读取
+	reader, _, err = db.GetReader([]byte("large"))
+	assert.Nil(t, err)
+	large2 := utils.RandomValue(3 * 1024 * 1024)
+	assert.Nil(t, db.PutReader([]byte("large"), bytes.NewReader(large2), int64(len(large2))))
+	blobFileNum := len(db.blobFiles)
+	assert.Nil(t, db.Merge())
+	assert.True(t, len(db.blobFiles) < blobFileNum)
+	buf, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, large, buf)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, 0, len(db.obsoleteFiles))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large2, val)
+	val, err = db.GetRange([]byte("large"), blobChunkSize-1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, large2[blobChunkSize-1:blobChunkSize+1], val)
+	destroyDB(db)
+}
@@ -0,0 +1,308 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxTxnWrites 有事务进行时记录的被修改 key 的最大数量，超过之后清空记录，正在进行并且读过数据的事务提交时都视为冲突
+const maxTxnWrites = 1 << 16
+
+// Txn 交互式读写事务，采用乐观并发控制
+// 事务开始时分配一个序列号，提交时检查读过的 key 在此之后是否被修改过，有修改则提交失败
+// 事务中的迭代器会记录遍历的前缀，在此之后有其他写入修改了该前缀下的 key（包括新增和删除）时提交同样失败，因此不会出现幻读
+// 写入的数据暂存在内存中，提交时复用 WriteBatch 的事务记录格式写入数据文件
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	readSeqNo     uint64                     // 事务开始时分配的序列号
+	reads         map[string]struct{}        // 事务读过的 key
+	prefixes      [][]byte                   // 事务中迭代器遍历过的 key 前缀
+	pendingWrites map[string]*data.LogRecord // 暂存事务写入的数据
+	syncWrites    bool                       // 提交时是否 sync 持久化
+	finished      bool                       // 事务是否已经提交或回滚
+}
+
+// Begin 开启一个交互式事务，事务结束时必须调用 Commit 或 Rollback
+// 有事务没有结束时数据库会一直记录被修改的 key 用于冲突检测，记录达到 maxTxnWrites 后被清空，所有正在进行并且读过数据的事务都无法提交
+func (db *DB) Begin(opts TxnOptions) (*Txn, error) {
+	// 与 WriteBatch 一样，B+ 树索引在没有事务序列号文件时不能使用事务
+	if !db.seqNoAvailable() {
+		return nil, ErrSeqNoNotAvailable
+	}
+
+	db.mu.Lock()
+	if db.closed.Load() {
+		db.mu.Unlock()
+		return nil, ErrDatabaseClosed
+	}
+
+	// 分配一个新的序列号，之后发生的修改都会被记录为不小于该序列号
+	readSeqNo := atomic.AddUint64(&db.seqNo, 1)
+	db.activeTxns++
+	var pendingSeq uint64
+	if n := len(db.unsyncedWrites); n > 0 {
+		pendingSeq = db.unsyncedWrites[n-1].seq
+	}
+	db.mu.Unlock()
+
+	// 之前的写入序列号小于 readSeqNo，冲突检测无法发现，等待持久化之后更新到索引中，事务才能读到它们
+	// 持久化失败时这些写入被丢弃，事务读到的是原来的数据，因此忽略持久化的错误
+	if pendingSeq > 0 {
+		_ = db.groupCommit.wait(db, pendingSeq)
+	}
+	return &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		readSeqNo:     readSeqNo,
+		reads:         make(map[string]struct{}),
+		pendingWrites: make(map[string]*data.LogRecord),
+		syncWrites:    opts.SyncWrites || db.options.SyncWrites,
+	}, nil
+}
+
+// Get 读取数据，优先读取事务中尚未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if record, ok := txn.pendingWrites[string(key)]; ok {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.reads[string(key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	if txn.db.closed.Load() {
+		return ErrDatabaseClosed
+	}
+
+	// 数据是否存在在提交时持有互斥锁判断，不存在的 key 不会写入删除标记
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 提交事务，读过的 key 在事务开始后被修改过则返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+
+	db := txn.db
+	// 只读模式下不能写入，事务直接结束，没有写入的事务可以正常提交
+	if db.options.ReadOnly {
+		db.mu.Lock()
+		db.finishTxn()
+		db.mu.Unlock()
+		if len(txn.pendingWrites) > 0 {
+			return ErrReadOnly
+		}
+		return nil
+	}
+	return db.update(txn.syncWrites, func() error {
+		defer db.finishTxn()
+
+		if txn.conflicted() {
+			return ErrTxnConflict
+		}
+
+		// 删除的 key 已经不存在，包括还在等待持久化的写入，不需要写入删除标记
+		for key, record := range txn.pendingWrites {
+			if record.Type == data.LogRecordDeleted && db.latestPos(record.Key) == nil {
+				delete(txn.pendingWrites, key)
+			}
+		}
+
+		if len(txn.pendingWrites) == 0 {
+			return nil
+		}
+		return db.commitPendingWrites(txn.pendingWrites)
+	})
+}
+
+// Rollback 回滚事务，丢弃所有暂存的写入，对已经结束的事务调用没有影响
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return
+	}
+	txn.finished = true
+	txn.pendingWrites = nil
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+	txn.db.finishTxn()
+}
+
+// conflicted 事务读过的 key 或者遍历过的前缀在事务开始之后是否被修改过，需要持有数据库的互斥锁
+func (txn *Txn) conflicted() bool {
+	db := txn.db
+	if len(txn.reads) == 0 && len(txn.prefixes) == 0 {
+		return false
+	}
+	// 冲突检测记录被清空过，无法判断是否有冲突
+	if txn.readSeqNo <= db.txnWritesFloor {
+		return true
+	}
+	for key := range txn.reads {
+		if seqNo, ok := db.txnWrites[key]; ok && seqNo >= txn.readSeqNo {
+			return true
+		}
+	}
+	if len(txn.prefixes) == 0 {
+		return false
+	}
+	for key, seqNo := range db.txnWrites {
+		if seqNo < txn.readSeqNo {
+			continue
+		}
+		for _, prefix := range txn.prefixes {
+			if strings.HasPrefix(key, string(prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// finishTxn 事务结束，没有进行中的事务时清空冲突检测记录，需要持有互斥锁
+func (db *DB) finishTxn() {
+	db.activeTxns--
+	if db.activeTxns == 0 {
+		db.txnWrites = make(map[string]uint64)
+	}
+}
+
+// TxnIterator 事务中的迭代器，合并了数据库中的数据和事务中暂存的写入
+type TxnIterator struct {
+	txn      *Txn
+	curIndex int
+	reverse  bool
+	keys     [][]byte
+}
+
+// NewIterator 初始化事务中的迭代器
+// 迭代器创建时确定 key 的集合，之后事务中的写入不会反映到迭代器中
+// 遍历的前缀会加入事务的读集合，提交前该前缀下有 key 被其他写入修改时返回 ErrTxnConflict
+func (txn *Txn) NewIterator(opts IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	keys := make(map[string]struct{})
+	if !txn.finished {
+		txn.prefixes = append(txn.prefixes, append([]byte{}, opts.Prefix...))
+		iterator := txn.db.NewIterator(IteratorOptions{Prefix: opts.Prefix})
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			keys[string(iterator.Key())] = struct{}{}
+		}
+		iterator.Close()
+
+		for key, record := range txn.pendingWrites {
+			if record.Type == data.LogRecordDeleted {
+				delete(keys, key)
+			} else if bytes.HasPrefix([]byte(key), opts.Prefix) {
+				keys[key] = struct{}{}
+			}
+		}
+	}
+
+	sortedKeys := make([][]byte, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, []byte(key))
+	}
+	sort.Slice(sortedKeys, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(sortedKeys[i], sortedKeys[j]) > 0
+		}
+		return bytes.Compare(sortedKeys[i], sortedKeys[j]) < 0
+	})
+
+	return &TxnIterator{
+		txn:     txn,
+		reverse: opts.Reverse,
+		keys:    sortedKeys,
+	}
+}
+
+// Rewind 重新回到迭代器的起点，即第一个数据
+func (it *TxnIterator) Rewind() {
+	it.curIndex = 0
+}
+
+// Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据从这个 key 开始遍历
+func (it *TxnIterator) Seek(key []byte) {
+	it.curIndex = sort.Search(len(it.keys), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.keys[i], key) <= 0
+		}
+		return bytes.Compare(it.keys[i], key) >= 0
+	})
+}
+
+// Next 跳转到下一个 key
+func (it *TxnIterator) Next() {
+	it.curIndex++
+}
+
+// Valid 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
+func (it *TxnIterator) Valid() bool {
+	return it.curIndex < len(it.keys)
+}
+
+// Key 当前遍历位置的 Key 数据
+func (it *TxnIterator) Key() []byte {
+	return it.keys[it.curIndex]
+}
+
+// Value 当前遍历位置的 Value 数据，读取的 key 会加入事务的读集合
+func (it *TxnIterator) Value() ([]byte, error) {
+	return it.txn.Get(it.keys[it.curIndex])
+}
+
+// Close 关闭迭代器，释放相应资源
+func (it *TxnIterator) Close() {
+	it.keys = nil
+}
@@ -0,0 +1,258 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Txn_Commit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-commit")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	txn, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	// 读到数据库中已有的数据
+	val, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	// 事务中的写入在提交前只对事务自己可见
+	err = txn.Put(utils.GetTestKey(1), []byte("2"))
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("2"))
+	assert.Nil(t, err)
+	val, err = txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), val)
+
+	iter := txn.NewIterator(DefaultIteratorOptions)
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)}, keys)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+
+	// 已经结束的事务不能再使用
+	err = txn.Put(utils.GetTestKey(3), []byte("3"))
+	assert.Equal(t, ErrTxnFinished, err)
+	txn.Rollback()
+
+	// 回滚的事务不产生任何修改
+	txn2, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	err = txn2.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	txn2.Rollback()
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// 重启后数据依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("1"))
+	assert.Nil(t, err)
+
+	// 1.读过的 key 被普通写入修改
+	txn1, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("txn1"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1), []byte("put"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrTxnConflict, txn1.Commit())
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("put"), val)
+
+	// 2.两个事务读写同一个 key，后提交的失败
+	txn2, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	txn3, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_ = txn2.Put(utils.GetTestKey(1), []byte("txn2"))
+	_ = txn3.Put(utils.GetTestKey(1), []byte("txn3"))
+	assert.Nil(t, txn2.Commit())
+	assert.Equal(t, ErrTxnConflict, txn3.Commit())
+
+	// 3.事务开始之前的写入不会导致冲突
+	err = db.Put(utils.GetTestKey(2), []byte("2"))
+	assert.Nil(t, err)
+	txn4, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	_, err = txn4.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), []byte("3"))
+	assert.Nil(t, err)
+	_ = txn4.Put(utils.GetTestKey(2), []byte("txn4"))
+	assert.Nil(t, txn4.Commit())
+	assert.Equal(t, 0, len(db.txnWrites))
+
+	// 4.遍历过的前缀下新增了 key
+	txn5, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	iter := txn5.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-")})
+	iter.Close()
+	_ = txn5.Put([]byte("count"), []byte("3"))
+	err = db.Put(utils.GetTestKey(4), []byte("4"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrTxnConflict, txn5.Commit())
+
+	// 5.遍历的前缀之外的写入不会导致冲突
+	txn6, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	iter = txn6.NewIterator(IteratorOptions{Prefix: []byte("bitcask-go-")})
+	iter.Close()
+	_ = txn6.Put([]byte("count"), []byte("4"))
+	err = db.Put([]byte("other"), []byte("other"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn6.Commit())
+
+	// 6.没有结束的事务导致冲突检测记录达到上限时被清空，读过数据的事务无法提交
+	abandoned, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	txn7, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	_, err = txn7.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	db.mu.Lock()
+	for i := 0; i < maxTxnWrites; i++ {
+		db.txnWrites[string(utils.GetTestKey(i+100))] = db.seqNo
+	}
+	db.mu.Unlock()
+	err = db.Put([]byte("other"), []byte("other"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.txnWrites))
+	assert.Equal(t, ErrTxnConflict, txn7.Commit())
+	// 之后开始的事务不受影响
+	txn8, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	_, err = txn8.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, txn8.Commit())
+	abandoned.Rollback()
+	assert.Equal(t, 0, db.activeTxns)
+}
+
+func TestDB_Txn_Options(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-options")
+	opts.DirPath = dir
+	opts.SyncWrites = false
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	txn, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	assert.True(t, txn.syncWrites)
+	txn.Rollback()
+	txn, err = db.Begin(TxnOptions{})
+	assert.Nil(t, err)
+	assert.False(t, txn.syncWrites)
+	txn.Rollback()
+
+	// 删除时 key 还不存在，提交时已经存在，仍然会被删除
+	txn, err = db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("1")))
+	assert.Nil(t, txn.Commit())
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	// 提交时 key 不存在，不会写入删除标记
+	reclaimSize := db.reclaimSize
+	txn, err = db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, reclaimSize, db.reclaimSize)
+}
+
+func TestDB_Txn_SeqNoNotAvailable(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-seq-no")
+	opts.DirPath = dir
+	opts.IndexerType = BPlusTreeIndex
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// B+ 树索引没有保存事务序列号的文件时不能开启事务
+	db.isInitial = false
+	db.seqNoFileExist = false
+	_, err = db.Begin(DefaultTxnOptions)
+	assert.Equal(t, ErrSeqNoNotAvailable, err)
+	assert.Equal(t, 0, db.activeTxns)
+}
+
+func TestDB_Txn_SeqNoFileTruncated(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-seq-no-truncated")
+	defer os.RemoveAll(dir)
+	// 数据目录不存在时才是第一次初始化，可以使用事务
+	opts.DirPath = filepath.Join(dir, "db")
+	opts.IndexerType = BPlusTreeIndex
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	txn, err := db.Begin(DefaultTxnOptions)
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Nil(t, txn.Commit())
+	assert.Nil(t, db.Close())
+
+	// 保存事务序列号的文件被截断时打开返回错误
+	fileName := filepath.Join(opts.DirPath, data.SeqNoFileName)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(fileName, stat.Size()/2))
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,72 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sort"
+)
+
+// DataFileUsage 一个数据文件中有效数据和无效数据的大小
+type DataFileUsage struct {
+	FileId    uint32 // 数据文件 id
+	Size      int64  // 数据文件的大小
+	LiveKeys  int    // 内存索引中指向该文件的 key 数量
+	LiveBytes int64  // 内存索引中指向该文件的数据大小
+	DeadBytes int64  // 没有被内存索引引用的数据大小，包括旧版本、删除标记、事务完成标识和文件结束标识
+}
+
+// DataFileUsage 根据当前的内存索引统计每个数据文件中有效数据和无效数据的大小，按照文件 id 从小到大排序
+func (db *DB) DataFileUsage() ([]DataFileUsage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+
+	dataFiles := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		dataFiles[fid] = dataFile
+	}
+	if db.activeFile != nil {
+		dataFiles[db.activeFile.FileId] = db.activeFile
+	}
+
+	usages := make(map[uint32]*DataFileUsage, len(dataFiles))
+	for fid, dataFile := range dataFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		usages[fid] = &DataFileUsage{FileId: fid, Size: size}
+	}
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		usage, ok := usages[pos.Fid]
+		if !ok {
+			continue
+		}
+		// 旧版本 merge 生成的索引中没有记录数据的大小，需要从数据文件中读取
+		size := int64(pos.Size)
+		if size == 0 {
+			_, recordSize, err := dataFiles[pos.Fid].ReadLogRecord(pos.Offset)
+			if err != nil {
+				return nil, err
+			}
+			size = recordSize
+		}
+		usage.LiveKeys++
+		usage.LiveBytes += size
+	}
+
+	result := make([]DataFileUsage, 0, len(usages))
+	for _, usage := range usages {
+		usage.DeadBytes = usage.Size - usage.LiveBytes
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].FileId < result[j].FileId
+	})
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DataFileUsage(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-usage")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空数据库
+	usages, err := db.DataFileUsage()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(usages))
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	usages, err = db.DataFileUsage()
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.olderFiles)+1, len(usages))
+	var liveKeys int
+	for _, usage := range usages {
+		// 旧数据文件末尾只有文件结束标识
+		if usage.FileId == db.activeFile.FileId {
+			assert.Equal(t, int64(0), usage.DeadBytes)
+		} else {
+			assert.Equal(t, int64(len(db.codec.EncodeEndOfFile())), usage.DeadBytes)
+		}
+		liveKeys += usage.LiveKeys
+	}
+	assert.Equal(t, 2000, liveKeys)
+
+	// 删除第一个文件中的数据
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	usages, err = db.DataFileUsage()
+	assert.Nil(t, err)
+	liveKeys = 0
+	for i, usage := range usages {
+		assert.Equal(t, usage.Size, usage.LiveBytes+usage.DeadBytes)
+		if i > 0 {
+			assert.True(t, usage.FileId > usages[i-1].FileId)
+		}
+		liveKeys += usage.LiveKeys
+	}
+	assert.Equal(t, 1900, liveKeys)
+	assert.True(t, usages[0].DeadBytes > 0)
+}
@@ -76,3 +76,47 @@ func CopyDir(src, dest string, exclude []string) error {
     return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode()) // 写入目标目录
   })
 }
+
+// WriteFileAtomic 将数据完整写入文件
+// 先写入临时文件并持久化，再重命名为目标文件，读到的文件要么是旧的要么是完整的新文件
+// 重命名之后持久化所在的目录，保证崩溃之后不会回到旧文件
+func WriteFileAtomic(fileName string, buf []byte, perm os.FileMode) error {
+  tmpFileName := fileName + ".tmp"
+  if err := WriteFileSync(tmpFileName, buf, perm); err != nil {
+    return err
+  }
+  if err := os.Rename(tmpFileName, fileName); err != nil {
+    return err
+  }
+  return SyncDir(filepath.Dir(fileName))
+}
+
+// SyncDir 持久化目录，保证目录中文件的新建、重命名和删除不会因为崩溃丢失
+func SyncDir(dirPath string) error {
+  dir, err := os.Open(dirPath)
+  if err != nil {
+    return err
+  }
+  if err := dir.Sync(); err != nil {
+    _ = dir.Close()
+    return err
+  }
+  return dir.Close()
+}
+
+// WriteFileSync 将数据写入文件并持久化，文件已经存在时会覆盖原有内容
+func WriteFileSync(fileName string, buf []byte, perm os.FileMode) error {
+  file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+  if err != nil {
+    return err
+  }
+  if _, err := file.Write(buf); err != nil {
+    _ = file.Close()
+    return err
+  }
+  if err := file.Sync(); err != nil {
+    _ = file.Close()
+    return err
+  }
+  return file.Close()
+}
@@ -18,3 +18,27 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, size > 0)
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-atomic")
+	defer os.RemoveAll(dir)
+	fileName := dir + "/file"
+
+	err := WriteFileAtomic(fileName, []byte("aaa"), 0644)
+	assert.Nil(t, err)
+	err = WriteFileAtomic(fileName, []byte("bb"), 0644)
+	assert.Nil(t, err)
+
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bb"), buf)
+	_, err = os.Stat(fileName + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSyncDir(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sync-dir")
+	defer os.RemoveAll(dir)
+	assert.Nil(t, SyncDir(dir))
+	assert.NotNil(t, SyncDir(dir+"/not-exist"))
+}